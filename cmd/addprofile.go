@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// validateExtends checks the parent of the profile exists without cycles
+func validateExtends(profile *ConnectionProfile) error {
+	if profile.Extends == "" {
+		return nil
+	}
+
+	allProfiles, err := getAllProfiles()
+	if err != nil {
+		return err
+	}
+	candidates := []*ConnectionProfile{profile}
+	for _, p := range allProfiles {
+		if p.EnvName != profile.EnvName {
+			candidates = append(candidates, p)
+		}
+	}
+	_, err = resolveProfile(candidates, profile.EnvName, nil)
+	return err
+}
+
+// addprofileCmd represents the addprofile command
+var addprofileCmd = &cobra.Command{
+	Use:   "add [--extends env] [--protected] [--host [user@]host[:port]]... [--identityFile path] [--force] [env]",
+	Short: "Add a connection profile, the hosts before the last one are used as jumpers",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hostSpecs := flags.Slice("host", nil, "")
+		identityFile := flags.String("identityFile", "", "")
+		force := flags.Bool("force", false, "")
+		extends := flags.String("extends", "", "")
+		protected := flags.Bool("protected", false, "")
+		flags.Parse()
+
+		profile := &ConnectionProfile{EnvName: args[0], Extends: extends(), Protected: protected()}
+		for _, spec := range hostSpecs() {
+			host, err := parseHostSpec(spec)
+			if err != nil {
+				return err
+			}
+			host.IdentityFile = identityFile()
+			profile.Hosts = append(profile.Hosts, host)
+		}
+		d, err := getProfileDefaults()
+		if err != nil {
+			return err
+		}
+		if err := profile.Validate(d); err != nil {
+			return err
+		}
+		if err := validateExtends(profile); err != nil {
+			return err
+		}
+
+		cf, err := loadConfigFile(configFilePath())
+		if err != nil {
+			return err
+		}
+		if cf.indexProfile(profile.EnvName) >= 0 && !force() {
+			return fmt.Errorf("profile %s already exists, use --force to replace it", profile.EnvName)
+		}
+		replaced, err := cf.SetProfile(profile)
+		if err != nil {
+			return err
+		}
+		if err := cf.Save(); err != nil {
+			return err
+		}
+
+		action := "added"
+		if replaced {
+			action = "replaced"
+		}
+		lg.Infof("Profile %s %s in %s", profile.EnvName, action, cf.path)
+		return nil
+	},
+}
+
+func init() {
+	profileCmd.AddCommand(addprofileCmd)
+	addprofileCmd.Flags().StringSlice("host", nil, "Host of the profile in the form of [user@]host[:port], repeat it for the jumpers in order")
+	addprofileCmd.Flags().String("identityFile", "", "Identity file of the hosts, use the defaults of the config if empty")
+	addprofileCmd.Flags().Bool("force", false, "Replace the profile if it already exists")
+	addprofileCmd.Flags().Bool("protected", false, "Protect the env, e.g. production, it is only connected with --confirm")
+	addprofileCmd.Flags().String("extends", "", "Env of the parent profile, whose hosts are prepended to the hosts, e.g. a shared bastion")
+	addprofileCmd.RegisterFlagCompletionFunc("extends", completeEnv)
+}
@@ -11,32 +11,80 @@ import (
 
 // appendCmd represents the append command
 var appendCmd = &cobra.Command{
-	Use:   "append [mesh] [service1] [service2] ...",
-	Short: "Append services to existing mesh",
-	Args:  cobra.MinimumNArgs(1),
+	Use:               "append [--env env] [--label key=value] [--file services.txt] [mesh] [service1] [service2] ...",
+	Short:             "Append services to existing mesh",
+	ValidArgsFunction: completeMeshArg,
+	Long: `Append services to existing mesh.
+	The services are reached through the env of the mesh by default,
+	provide --env to reach them through another env, e.g. a staging service in a dev mesh:
+
+	ssh-proxy mesh append --env staging mesh-test auth:443
+	`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		labelPairs := flags.Slice("label", nil, "")
+		file := flags.String("file", "", "")
 		flags.Parse()
 		meshName := args[0]
-		services, err := parseHostPortPairs(args[1:]...)
+		serviceArgs, err := appendServiceFile(args[1:], file())
 		if err != nil {
 			return err
 		}
+		labels, err := server.ParseLabels(labelPairs()...)
+		if err != nil {
+			return err
+		}
+		if len(labels) == 0 {
+			labels = nil
+		}
 
-		serviceMesh := server.NewServiceMesh()
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+		mesh, err := serviceMesh.GetMesh(meshName)
+		if err != nil {
+			return err
+		}
+
+		var serviceEnv string
+		if env() != "" && env() != mesh.Env {
+			serviceEnv = env()
+		}
 
+		// the services can be the named services of the env they are reached through
+		profile, _ := getProfile(mesh.GetEnv(server.Service{Env: serviceEnv}))
+		services, err := parseProfileHostPort(profile, serviceArgs...)
+		if err != nil {
+			return err
+		}
+
+		appends := make([]*server.Service, 0, len(services))
 		for _, service := range services {
-			if err := serviceMesh.AddServiceToMesh(meshName, &server.Service{
-				RemoteAddr:  service.RemoteAddress,
-				ServiceName: service.ServiceName,
-			}); err != nil {
-				return err
+			serviceLabels := labels
+			if len(service.Labels) > 0 {
+				serviceLabels = make(map[string]string)
+				for k, v := range service.Labels {
+					serviceLabels[k] = v
+				}
+				for k, v := range labels {
+					serviceLabels[k] = v
+				}
 			}
+			appends = append(appends, &server.Service{
+				RemoteAddr:  service.ProxyAddress,
+				ServiceName: service.ServiceName,
+				Env:         serviceEnv,
+				Labels:      serviceLabels,
+			})
 		}
 
-		return nil
+		return serviceMesh.AddServiceToMesh(meshName, appends...)
 	},
 }
 
 func init() {
 	meshCmd.AddCommand(appendCmd)
+	appendCmd.Flags().StringSlice("label", nil, "Labels of the appended services, e.g. --label tier=db")
+	appendCmd.Flags().String("file", "", "File of the services to append, one per line, - for stdin")
 }
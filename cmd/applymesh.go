@@ -0,0 +1,44 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// applymeshCmd represents the applymesh command
+var applymeshCmd = &cobra.Command{
+	Use:   "apply --file meshes.yaml [--prune]",
+	Short: "Reconcile the meshes in the store into the ones declared in the file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := flags.String("file", "", "")
+		prune := flags.Bool("prune", false, "")
+		flags.Parse()
+
+		desired, err := readDesiredMeshes(file())
+		if err != nil {
+			return err
+		}
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+
+		changes, err := serviceMesh.ApplyMeshes(desired, prune())
+		if err != nil {
+			return err
+		}
+		lg.Infof("Mesh applied: \n%s", prettyMeshChanges(changes))
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(applymeshCmd)
+	applymeshCmd.Flags().String("file", "", "File of the desired meshes, - for stdin")
+	applymeshCmd.Flags().Bool("prune", false, "Remove the meshes not in the file")
+}
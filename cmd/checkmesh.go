@@ -0,0 +1,100 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+func prettyCheckResults(results []server.CheckResult) string {
+	buffer := &bytes.Buffer{}
+	table := tablewriter.NewWriter(buffer)
+	table.Append([]string{"Env", "Check", "Name", "Address", "Latency", "Result"})
+	for _, r := range results {
+		result := "PASS"
+		if !r.OK() {
+			result = "FAIL: " + r.Err.Error()
+		}
+		table.Append([]string{r.Env, r.Kind, r.Name, r.Address, r.Latency.Round(time.Millisecond).String(), result})
+	}
+	table.Render()
+	return buffer.String()
+}
+
+// checkmeshCmd represents the checkmesh command
+var checkmeshCmd = &cobra.Command{
+	Use:               "check [--set key=value] [--timeout 5s] [mesh]",
+	Short:             "Check the ssh hops and services of a mesh are reachable without binding ports",
+	ValidArgsFunction: completeMeshArg,
+	Args:              cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		set := flags.Slice("set", nil, "")
+		timeout := flags.Duration("timeout", 5*time.Second, "")
+		flags.Parse()
+
+		vars, err := parseVars(set())
+		if err != nil {
+			return err
+		}
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+		mesh, err := serviceMesh.ExpandMesh(args[0])
+		if err != nil {
+			return err
+		}
+		mesh, err = mesh.Render(vars)
+		if err != nil {
+			return err
+		}
+
+		envServices := mesh.GroupServicesByEnv()
+		envs := make([]string, 0, len(envServices))
+		for env := range envServices {
+			envs = append(envs, env)
+		}
+		sort.Strings(envs)
+
+		var results []server.CheckResult
+		for _, env := range envs {
+			profile, err := getDialProfile(env)
+			if err != nil {
+				results = append(results, server.CheckResult{Env: env, Kind: server.CheckHop, Err: err})
+				continue
+			}
+			results = append(results, server.CheckEnv(env, profile.Hosts, envServices[env], timeout())...)
+		}
+
+		lg.Infof("Mesh %s check results: \n%s", mesh.Name, prettyCheckResults(results))
+
+		var failed int
+		for _, r := range results {
+			if !r.OK() {
+				failed++
+			}
+		}
+		if failed > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("mesh %s check failed: %d of %d checks failed", mesh.Name, failed, len(results))
+		}
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(checkmeshCmd)
+	checkmeshCmd.Flags().StringSlice("set", nil, "Override the variables of the mesh, e.g. --set shard=42")
+	checkmeshCmd.Flags().Duration("timeout", 5*time.Second, "Timeout of each ssh hop and service dial")
+}
@@ -9,33 +9,18 @@ import (
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/pkg/errors"
+	"github.com/superwhys/ssh-proxy/server"
 	"github.com/superwhys/ssh-proxy/sshproxypb"
 )
 
-func prettySlices(slice []string) string {
-	buffer := &bytes.Buffer{}
-	table := tablewriter.NewWriter(buffer)
-
-	var current []string
-	for i, s := range slice {
-		current = append(current, s)
-		if (i+1)%5 == 0 {
-			table.Append(current)
-			current = nil
-		}
-	}
-	if len(current) > 0 {
-		table.Append(current)
-	}
-	table.Render()
-	return buffer.String()
-}
-
-func prettyMaps(m map[string][]*sshproxypb.Node) string {
+// prettyMaps renders the nodes grouped by host,
+// the env column is filled by hostEnvs if provided
+func prettyMaps(m map[string][]*sshproxypb.Node, hostEnvs map[string]string) string {
 	buffer := &bytes.Buffer{}
 	table := tablewriter.NewWriter(buffer)
 
 	type Record struct {
+		Env           string
 		Host          string
 		ServiceName   string
 		RemoteAddress string
@@ -47,6 +32,7 @@ func prettyMaps(m map[string][]*sshproxypb.Node) string {
 		for _, node := range connectNode {
 			_, port, _ := net.SplitHostPort(node.GetLocalAddress())
 			r := &Record{
+				Env:           hostEnvs[host],
 				Host:          host,
 				ServiceName:   node.GetServiceName(),
 				RemoteAddress: node.GetRemoteAddress(),
@@ -57,6 +43,9 @@ func prettyMaps(m map[string][]*sshproxypb.Node) string {
 		}
 	}
 	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Env != rs[j].Env {
+			return rs[i].Env < rs[j].Env
+		}
 		if rs[i].Host == rs[j].Host {
 			if rs[i].ServiceName == rs[j].ServiceName {
 				return rs[i].Port < rs[j].Port
@@ -66,9 +55,17 @@ func prettyMaps(m map[string][]*sshproxypb.Node) string {
 
 		return rs[i].Host < rs[j].Host
 	})
-	table.Append([]string{"Host", "Service", "Remote Address", "Local Port", "Debug URL"})
+	header := []string{"Host", "Service", "Remote Address", "Local Port", "Debug URL"}
+	if hostEnvs != nil {
+		header = append([]string{"Env"}, header...)
+	}
+	table.Append(header)
 	for _, r := range rs {
-		table.Append([]string{r.Host, r.ServiceName, r.RemoteAddress, r.Port, r.DebugURL})
+		row := []string{r.Host, r.ServiceName, r.RemoteAddress, r.Port, r.DebugURL}
+		if hostEnvs != nil {
+			row = append([]string{r.Env}, row...)
+		}
+		table.Append(row)
 	}
 	table.Render()
 	return buffer.String()
@@ -80,58 +77,113 @@ func prettyLocalAddr(addr string) string {
 	return "http://localhost:" + addr + "/debug"
 }
 
-func parseProfileHostPort(args ...string) ([]*sshproxypb.Service, error) {
+// parseProfileHostPort parses the services to connect through the profile, each arg is
+// a named service of the profile with an optional @[bind:]localport, or [name=]target:port[@[bind:]localport]
+func parseProfileHostPort(profile *ConnectionProfile, args ...string) ([]*sshproxypb.Service, error) {
+	var aliases map[string]*ProfileService
+	if profile != nil {
+		var err error
+		if aliases, err = profile.ServiceAliases(); err != nil {
+			return nil, err
+		}
+	}
+
 	var proxyHosts []*sshproxypb.Service
 	dupService := make(map[string]bool)
 
-	for _, arg := range args {
-		_, _, err := net.SplitHostPort(arg)
-		if err != nil {
-			return nil, errors.Wrap(err, "host:port format invalid")
+	for i, arg := range args {
+		var service *sshproxypb.Service
+		name, local, hasLocal := strings.Cut(arg, "@")
+		if aliasName, alias, ok := lookupAlias(aliases, name); ok {
+			service = &sshproxypb.Service{
+				ServiceName:  aliasName,
+				ProxyAddress: alias.Address,
+				LocalPort:    int32(alias.LocalPort),
+				Labels:       alias.Labels,
+			}
+			if hasLocal {
+				// the local address of the alias follows the same grammar
+				spec, err := server.ParseServiceSpec(alias.Address+"@"+local, false)
+				if err != nil {
+					return nil, errors.Wrapf(err, "arg %d", i+1)
+				}
+				service.LocalPort = int32(spec.LocalPort)
+				service.LocalHost = spec.LocalHost
+			}
+		} else {
+			spec, err := server.ParseServiceSpec(arg, false)
+			if err != nil {
+				if profile != nil && !strings.ContainsAny(arg, ":=") {
+					return nil, fmt.Errorf("arg %d: %s is neither a host:port nor a service of env %s, see ssh-proxy profile show %s", i+1, arg, profile.EnvName, profile.EnvName)
+				}
+				return nil, errors.Wrapf(err, "arg %d", i+1)
+			}
+			service = spec.Service()
 		}
 
-		if _, ok := dupService[arg]; ok {
+		if _, ok := dupService[service.ProxyAddress]; ok {
 			continue
 		}
 
-		proxyHosts = append(proxyHosts, &sshproxypb.Service{
-			ServiceName:   arg,
-			RemoteAddress: "",
-			ProxyAddress:  arg,
-		})
-		dupService[arg] = true
+		proxyHosts = append(proxyHosts, service)
+		dupService[service.ProxyAddress] = true
 	}
 	return proxyHosts, nil
 }
 
+// checkRendered rejects the templated addresses outside of meshes, which have no variables to render them
+func checkRendered(services []*sshproxypb.Service) error {
+	for _, service := range services {
+		if strings.Contains(service.ProxyAddress, "{{") {
+			return fmt.Errorf("invalid address %q, variables are only supported in meshes", service.ProxyAddress)
+		}
+	}
+	return nil
+}
+
+// parseHostPortPairs parses the services of direct mode, each arg is
+// [name=][user@]sshhost[:port]/target:port[@[bind:]localport], a ssh:// URI,
+// or a pair of sshHost:sshPort proxyHost:proxyPort args
 func parseHostPortPairs(args ...string) ([]*sshproxypb.Service, error) {
 	var proxyHosts []*sshproxypb.Service
 	dupService := make(map[string]bool)
 
-	appendProxy := func(remoteAddr, proxyAddr string) {
-		dupKey := fmt.Sprintf("%v:%v", remoteAddr, proxyAddr)
-		if _, ok := dupService[dupKey]; ok {
-			return
+	for i := 0; i < len(args); i++ {
+		spec, pos := args[i], fmt.Sprintf("arg %d", i+1)
+		if !strings.Contains(spec, "/") {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s: invalid service %q: missing the proxyHost:proxyPort after sshHost:sshPort, or use sshHost/proxyHost:proxyPort", pos, spec)
+			}
+			spec, pos = spec+"/"+args[i+1], fmt.Sprintf("args %d-%d", i+1, i+2)
+			i++
 		}
-		proxyHosts = append(proxyHosts, &sshproxypb.Service{
-			ServiceName:   proxyAddr,
-			RemoteAddress: remoteAddr,
-			ProxyAddress:  proxyAddr,
-		})
-		dupService[dupKey] = true
-	}
 
-	var err error
-	for i := 0; i < len(args); {
-		_, _, err = net.SplitHostPort(args[i])
-		_, _, err = net.SplitHostPort(args[i+1])
+		parsed, err := server.ParseServiceSpec(spec, true)
 		if err != nil {
-			return nil, errors.Wrap(err, "host:port format invalid")
+			return nil, errors.Wrap(err, pos)
 		}
+		service := parsed.Service()
 
-		appendProxy(args[i], args[i+1])
-		i += 2
+		dupKey := fmt.Sprintf("%v:%v", service.RemoteAddress, service.ProxyAddress)
+		if _, ok := dupService[dupKey]; ok {
+			continue
+		}
+		proxyHosts = append(proxyHosts, service)
+		dupService[dupKey] = true
 	}
 
 	return proxyHosts, nil
 }
+
+// parseVars parses key=value pairs provided by flags
+func parseVars(pairs []string) (map[string]string, error) {
+	vars := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid variable %q, it should be key=value", pair)
+		}
+		vars[k] = v
+	}
+	return vars, nil
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestParseProfileHostPort(t *testing.T) {
+	profile := &ConnectionProfile{EnvName: "dev", Services: map[string]interface{}{
+		"redis": "10.0.0.5:6379",
+		"MySQL": map[string]interface{}{"Address": "10.0.0.6:3306", "LocalPort": 13306},
+	}}
+	tests := []struct {
+		arg, name, proxyAddr string
+		localPort            int32
+		wantErr              bool
+	}{
+		{arg: "redis", name: "redis", proxyAddr: "10.0.0.5:6379"},
+		{arg: "Redis@16379", name: "redis", proxyAddr: "10.0.0.5:6379", localPort: 16379},
+		{arg: "MYSQL", name: "mysql", proxyAddr: "10.0.0.6:3306", localPort: 13306},
+		{arg: "cache=10.0.0.8:6379", name: "cache", proxyAddr: "10.0.0.8:6379"},
+		{arg: "mongo", wantErr: true},
+	}
+	for _, tt := range tests {
+		services, err := parseProfileHostPort(profile, tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseProfileHostPort(%q) error = %v, wantErr %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if len(services) != 1 {
+			t.Fatalf("parseProfileHostPort(%q) = %v, want 1 service", tt.arg, services)
+		}
+		if s := services[0]; s.GetServiceName() != tt.name || s.GetProxyAddress() != tt.proxyAddr || s.GetLocalPort() != tt.localPort {
+			t.Errorf("parseProfileHostPort(%q) = %v, want %s %s local port %d", tt.arg, s, tt.name, tt.proxyAddr, tt.localPort)
+		}
+	}
+}
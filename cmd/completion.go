@@ -0,0 +1,185 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+)
+
+var parseOnce sync.Once
+
+// parseConfig parses the config file for the completions, flags.Parse panics if called twice
+func parseConfig() {
+	parseOnce.Do(flags.Parse)
+}
+
+// completeNames returns the names with the prefix except the excluded ones,
+// the names are read when the hidden __complete command of cobra runs,
+// so the config file is parsed first for the profiles and mesh store
+func completeNames(names []string, exclude []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	excluded := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+
+	var completions []string
+	for _, name := range names {
+		if strings.HasPrefix(name, toComplete) && !excluded[name] {
+			completions = append(completions, name)
+		}
+	}
+	sort.Strings(completions)
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+func envNames() []string {
+	parseConfig()
+	allProfiles, err := getAllProfiles()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(allProfiles))
+	for _, profile := range allProfiles {
+		names = append(names, profile.EnvName)
+	}
+	return names
+}
+
+func meshNames() []string {
+	parseConfig()
+	serviceMesh, err := newServiceMesh()
+	if err != nil {
+		return nil
+	}
+	meshes, err := serviceMesh.GetAllMeshes()
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(meshes))
+	for _, mesh := range meshes {
+		names = append(names, mesh.Name)
+	}
+	return names
+}
+
+func meshServiceNames(meshName string) []string {
+	parseConfig()
+	serviceMesh, err := newServiceMesh()
+	if err != nil {
+		return nil
+	}
+	mesh, err := serviceMesh.ExpandMesh(meshName)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(mesh.Services))
+	for _, service := range mesh.Services {
+		names = append(names, service.ServiceName)
+	}
+	return names
+}
+
+// completeEnv completes the envs of the profiles
+func completeEnv(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeNames(envNames(), nil, toComplete)
+}
+
+// completeEnvArg completes the first arg with the envs of the profiles
+func completeEnvArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeEnv(cmd, args, toComplete)
+}
+
+// completeMeshes completes all the args with the meshes
+func completeMeshes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeNames(meshNames(), args, toComplete)
+}
+
+// completeMeshArg completes the first arg with the meshes
+func completeMeshArg(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeMeshes(cmd, args, toComplete)
+}
+
+// completeMeshServices completes the first arg with the meshes
+// and the rest with the services of the mesh
+func completeMeshServices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return completeMeshes(cmd, args, toComplete)
+	}
+	return completeNames(meshServiceNames(args[0]), args[1:], toComplete)
+}
+
+// completeMeshServiceFlag completes a flag with the services of the mesh in the first arg
+func completeMeshServiceFlag(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeNames(meshServiceNames(args[0]), nil, toComplete)
+}
+
+// completeProfileServices completes the args with the named services of the current env
+func completeProfileServices(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	parseConfig()
+	envName := currentEnv()
+	if envName == "" {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	profile, err := getProfile(envName)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	aliases, err := profile.ServiceAliases()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	return completeNames(names, args, toComplete)
+}
+
+// completeNodes completes the args with the services connected by the running ssh-proxy
+func completeNodes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	parseConfig()
+	conn, err := dialInstance()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := sshproxypb.NewServiceTunnelClient(conn).GetConnectNodes(ctx, &sshproxypb.GetConnectNodesRequest{})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := make([]string, 0, len(resp.GetConnectedNodes()))
+	for _, node := range resp.GetConnectedNodes() {
+		names = append(names, node.GetServiceName())
+	}
+	return completeNames(names, args, toComplete)
+}
+
+func init() {
+	// the global flags are merged into the root command by cobra on execute,
+	// which is too late to register their completions
+	rootCmd.PersistentFlags().AddFlagSet(pflag.CommandLine)
+	rootCmd.RegisterFlagCompletionFunc("env", completeEnv)
+	rootCmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{"table", "json", "yaml"}, cobra.ShellCompDirectiveNoFileComp))
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+func TestCompleteNames(t *testing.T) {
+	tests := []struct {
+		names, exclude []string
+		toComplete     string
+		want           []string
+	}{
+		{[]string{"mysql", "redis", "mongo"}, nil, "", []string{"mongo", "mysql", "redis"}},
+		{[]string{"mysql", "redis", "mongo"}, nil, "m", []string{"mongo", "mysql"}},
+		{[]string{"mysql", "redis", "mongo"}, []string{"mysql"}, "m", []string{"mongo"}},
+		{[]string{"mysql", "redis"}, nil, "x", nil},
+	}
+	for _, tt := range tests {
+		got, directive := completeNames(tt.names, tt.exclude, tt.toComplete)
+		if !reflect.DeepEqual(got, tt.want) || directive != cobra.ShellCompDirectiveNoFileComp {
+			t.Errorf("completeNames(%v, %v, %q) = %v, %v, want %v", tt.names, tt.exclude, tt.toComplete, got, directive, tt.want)
+		}
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	// the completions parse the flags, which should not see the flags of go test
+	args := os.Args
+	os.Args = []string{"ssh-proxy"}
+	t.Cleanup(func() { os.Args = args })
+
+	path := filepath.Join(t.TempDir(), "meshes.json")
+	store := server.NewFileMeshStore(path)
+	for _, mesh := range []server.Mesh{
+		{Name: "mesh-a", Env: "dev", Includes: []string{"mesh-b"}, Services: []server.Service{{ServiceName: "redis", RemoteAddr: "redis:6379"}}},
+		{Name: "mesh-b", Env: "dev", Services: []server.Service{{ServiceName: "mysql", RemoteAddr: "mysql:3306"}}},
+	} {
+		if err := server.NewServiceMesh(store).CreateMesh(mesh); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v := flags.Viper()
+	v.Set("meshStore", server.MeshStoreFile)
+	v.Set("meshStorePath", path)
+	v.Set("env", "dev")
+	v.Set("profiles", []map[string]interface{}{
+		{"EnvName": "dev", "Hosts": []map[string]interface{}{{"HostName": "10.0.0.1"}}, "Services": map[string]interface{}{"api": "api:8080", "web": "web:80"}},
+		{"EnvName": "staging", "Hosts": []map[string]interface{}{{"HostName": "10.0.1.1"}}},
+	})
+	t.Cleanup(func() {
+		v.Set("meshStorePath", "")
+		v.Set("env", "")
+		v.Set("profiles", []map[string]interface{}{})
+	})
+
+	tests := []struct {
+		name       string
+		complete   func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective)
+		args       []string
+		toComplete string
+		want       []string
+	}{
+		{"envs", completeEnv, nil, "", []string{"dev", "staging"}},
+		{"envs with prefix", completeEnv, nil, "s", []string{"staging"}},
+		{"env arg given", completeEnvArg, []string{"dev"}, "", nil},
+		{"meshes", completeMeshes, []string{"mesh-a"}, "", []string{"mesh-b"}},
+		{"mesh arg given", completeMeshArg, []string{"mesh-a"}, "", nil},
+		{"mesh services with includes", completeMeshServices, []string{"mesh-a"}, "", []string{"mysql", "redis"}},
+		{"mesh services given", completeMeshServices, []string{"mesh-a", "redis"}, "", []string{"mysql"}},
+		{"mesh service flag", completeMeshServiceFlag, []string{"mesh-b"}, "", []string{"mysql"}},
+		{"profile services", completeProfileServices, []string{"api"}, "", []string{"web"}},
+	}
+	for _, tt := range tests {
+		got, _ := tt.complete(nil, tt.args, tt.toComplete)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: completions of %v %q = %v, want %v", tt.name, tt.args, tt.toComplete, got, tt.want)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -26,49 +27,89 @@ func isTCPAddr(addr string) bool {
 	return err == nil
 }
 
+// isDirectSpec reports whether the args give their ssh hosts,
+// as sshHost/proxyHost:proxyPort or ssh://sshHost/proxyHost:proxyPort,
+// the sshHost:sshPort proxyHost:proxyPort pairs need --direct when there is a current env
+func isDirectSpec(args []string) bool {
+	for _, arg := range args {
+		if strings.Contains(arg, "/") {
+			return true
+		}
+	}
+	return false
+}
+
 // connectCmd represents the connect command
 var connectCmd = &cobra.Command{
-	Use:   "connect [options] [sshHost:sshPort proxyHost:proxyPort...] | [proxyHost:proxyPort]",
-	Short: "Proxy the proxyHost:proxyPort to the local through sshHost",
+	Use:               "connect [options] [[name=][user@]sshHost[:sshPort]/proxyHost:proxyPort[@[bind:]localPort]...] | [[name=]proxyHost:proxyPort[@[bind:]localPort]...]",
+	Short:             "Proxy the proxyHost:proxyPort to the local through sshHost",
+	ValidArgsFunction: completeProfileServices,
 	Long: `Proxy the proxyHost:proxyPort to the local through sshHost. 
 	It is similar to a forward proxy for ssh.
 	You can provide the remote side which need to be connect and the proxy side to proxy the service to local like:
 
 	ssh-proxy sshHost:sshPort localhost:8000
+	ssh-proxy web=admin@sshHost/localhost:8000@18000 ssh://sshHost:2222/localhost:9000
 
 	or 
 	You can use the profile config to define some alias of remote side, so that use can just connect to remote like:
 
-	ssh-proxy --env aliasName localhost:8000
+	ssh-proxy --env aliasName localhost:8000 redis@16379 db=mysql.internal:3306@127.0.0.1:13306
+
+	the current env selected by ssh-proxy use is skipped when the args give their ssh hosts,
+	add --direct to connect the sshHost:sshPort proxyHost:proxyPort pairs without it
+
+	the services can also be listed in a file, one per line, or piped in with --file -
 
 	`,
-	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		user := flags.String("user", "root", "")
-
+		user := flags.String("user", "", "")
+		direct := flags.Bool("direct", false, "")
+		file := flags.String("file", "", "")
 		flags.Parse()
+
 		if lg.IsDebug() {
 			lg.Info("is debug")
 		}
+		args, err := appendServiceFile(args, file())
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return errors.New("no services provide, add them as args or --file services.txt")
+		}
 
-		var err error
-		if env() == "" {
-			if len(args)%2 != 0 {
-				return errors.New("args is not a valid sshHost and proxyHost pairs")
-			}
-
+		if direct() && env() != "" {
+			return errors.New("--direct can not be used with --env")
+		}
+		envName := currentEnv()
+		if envName != "" && env() == "" && (direct() || isDirectSpec(args)) {
+			// the current env selected by ssh-proxy use does not apply to the ssh hosts given in the args
+			envName = ""
+		}
+		if envName == "" {
 			proxyHosts, err := parseHostPortPairs(args...)
 			if err != nil {
 				return errors.Wrap(err, "parse host pairs")
 			}
 
-			err = startConnectDirect(user(), privateKeyPath(), proxyHosts)
+			err = startConnectDirect(user(), proxyHosts)
 		} else {
-			proxyHosts, err := parseProfileHostPort(args...)
+			if env() == "" {
+				lg.Infof("Using current env %s, see ssh-proxy context", envName)
+			}
+			profile, err := getProfile(envName)
+			if err != nil {
+				return err
+			}
+			proxyHosts, err := parseProfileHostPort(profile, args...)
 			if err != nil {
 				return errors.Wrap(err, "parse profile hostPort")
 			}
-			err = startConnect(proxyHosts)
+			if err := checkRendered(proxyHosts); err != nil {
+				return err
+			}
+			err = startConnect(map[string][]*sshproxypb.Service{envName: proxyHosts})
 		}
 		if err != nil {
 			lg.Errorf("Failed to start connect: %v", err)
@@ -78,82 +119,111 @@ var connectCmd = &cobra.Command{
 	},
 }
 
-func dialTunnel() (*sshtunnel.SshTunnel, error) {
-	var profile *ConnectionProfile
-	var allProfiles []*ConnectionProfile
-	profiles(&allProfiles)
-	for _, p := range allProfiles {
-		if p.EnvName == env() {
-			profile = p
-			break
-		}
+// isProtected reports whether the env is protected by its profile or its parents
+func isProtected(envName string) bool {
+	profile, err := getProfile(envName)
+	return err == nil && profile.Protected
+}
+
+// confirmEnvs checks the protected envs to connect are confirmed by --confirm,
+// which only applies to the envs of the command line, not to the later requests of the session
+func confirmEnvs(envServices map[string][]*sshproxypb.Service) error {
+	envs := server.ProtectedEnvs(envServices, isProtected)
+	if len(envs) == 0 {
+		return nil
 	}
-	if profile == nil {
-		return nil, fmt.Errorf("No connection profile found. env=%s", env())
+	if !confirm() {
+		return fmt.Errorf("envs %s are protected, add --confirm to connect them", strings.Join(envs, ", "))
 	}
-	profile.PopulateDefault(privateKeyPath())
+	lg.Warnf("Connecting the protected envs: %s", strings.Join(envs, ", "))
+	return nil
+}
+
+func dialTunnel(envName string) (tunnel *sshtunnel.SshTunnel, err error) {
+	profile, err := getProfile(envName)
+	if err != nil {
+		return nil, err
+	}
+	d, err := getProfileDefaults()
+	if err != nil {
+		return nil, err
+	}
+	profile.PopulateDefault(d)
+	// NewTunnel panics if it fails to dial,
+	// which should not bring down a running session
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("dial tunnel of env %s: %v", envName, r)
+		}
+	}()
 
 	lg.Infof("Connecting remote services with profile:\n%s", lg.Jsonify(profile))
-	tunnel := sshtunnel.NewTunnel(profile.Hosts...)
+	tunnel = sshtunnel.NewTunnel(profile.Hosts...)
+	keepAlive(tunnel, d.Keepalive)
 
 	return tunnel, nil
 }
 
-func dialDirectTunnel(user, host, identityFile string) *sshtunnel.SshTunnel {
+// dialDirectTunnel dials the ssh host given in the args,
+// the defaults of the config are applied as to the hosts of a profile
+func dialDirectTunnel(user, host string) (tunnel *sshtunnel.SshTunnel, err error) {
+	d, err := getProfileDefaults()
+	if err != nil {
+		return nil, err
+	}
 	profile := &ConnectionProfile{
 		EnvName: "direct",
 		Hosts: []*sshtunnel.SshConfig{
-			{HostName: host, User: user, IdentityFile: identityFile},
+			{HostName: host, User: user},
 		},
 	}
+	profile.PopulateDefault(d)
+
+	// NewTunnel panics if it fails to dial
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("dial tunnel of %v: %v", host, r)
+		}
+	}()
 
 	lg.Info(lg.Jsonify(profile))
 
-	return sshtunnel.NewTunnel(profile.Hosts...)
+	tunnel = sshtunnel.NewTunnel(profile.Hosts...)
+	keepAlive(tunnel, d.Keepalive)
+
+	return tunnel, nil
 }
 
-func startConnectDirect(user, identityFile string, proxyHosts []*sshproxypb.Service) error {
+// startConnectDirect connects the services through the ssh hosts given in the args,
+// user is used for the hosts without one and the defaults of the config apply if it is empty
+func startConnectDirect(user string, proxyHosts []*sshproxypb.Service) error {
 	lg.Info("connect direct")
 
-	table := map[string][]*sshproxypb.Node{}
+	serviceMesh, err := newServiceMesh()
+	if err != nil {
+		return err
+	}
+
 	serviceTunnel := server.NewServiceTunnel()
-	connectServices := make([]*sshproxypb.Service, 0)
-	tunnelCache := make(map[string]*sshtunnel.SshTunnel)
-	defer func() {
-		for _, st := range tunnelCache {
-			st.Close()
-		}
-		serviceTunnel.Close()
-	}()
+	defer serviceTunnel.Close()
+	watchDotenv(serviceTunnel)
 
 	ctx := context.Background()
 
-	for _, pair := range proxyHosts {
-		sshHost := pair.RemoteAddress
-
-		if _, exists := tunnelCache[sshHost]; !exists {
-			tunnel := dialDirectTunnel(user, sshHost, identityFile)
-			lg.Infof("dial ssh tunnel success: %v", sshHost)
-			serviceTunnel.DialTunnel(tunnel)
-			tunnelCache[sshHost] = tunnel
+	nodes, err := serviceTunnel.ConnectDirect(ctx, proxyHosts, func(hostUser, host string) (*sshtunnel.SshTunnel, error) {
+		if hostUser == "" {
+			hostUser = user
 		}
-
-		connectServices = append(connectServices, pair)
-	}
-
-	resp, err := serviceTunnel.Connect(ctx, &sshproxypb.ConnectRequest{
-		Services: connectServices,
+		return dialDirectTunnel(hostUser, host)
 	})
 	if err != nil {
 		lg.Errorc(ctx, "Failed to connect remote services: %v", err)
 		return errors.Wrap(err, "tunnelConnect")
 	}
-	for _, node := range resp.GetConnectedNodes() {
-		table[node.GetHostAddress()] = append(table[node.GetHostAddress()], node)
+	if err := printNodes(nodes, nil); err != nil {
+		return err
 	}
 
-	lg.Info("Connected services\n" + prettyMaps(table))
-
 	serviceOpts := []service.SuperServiceOption{
 		service.WithGRPCUI(),
 		service.WithPprof(),
@@ -161,6 +231,7 @@ func startConnectDirect(user, identityFile string, proxyHosts []*sshproxypb.Serv
 
 	serviceOpts = append(serviceOpts, service.WithGRPC(func(srv *grpc.Server) {
 		sshproxypb.RegisterServiceTunnelServer(srv, serviceTunnel)
+		sshproxypb.RegisterMeshServiceServer(srv, server.NewMeshServer(serviceMesh, serviceTunnel, dialTunnel, isProtected))
 	}))
 
 	srv := service.NewSuperService(serviceOpts...)
@@ -168,39 +239,36 @@ func startConnectDirect(user, identityFile string, proxyHosts []*sshproxypb.Serv
 }
 
 // startConnect used to connect remote services with tunnel
-// By default, all services connected at a single time are under the same host
-func startConnect(proxyHosts []*sshproxypb.Service) error {
+// services are grouped by env, and each env is connected by its own tunnel
+func startConnect(envServices map[string][]*sshproxypb.Service) error {
 	ctx := context.Background()
-	tunnel, err := dialTunnel()
-	if err != nil {
+	if err := confirmEnvs(envServices); err != nil {
 		return err
 	}
 
-	for _, ph := range proxyHosts {
-		ph.RemoteAddress = tunnel.GetRemoteHost()
+	serviceMesh, err := newServiceMesh()
+	if err != nil {
+		return err
 	}
 
 	st := server.NewServiceTunnel()
-	st.DialTunnel(tunnel)
 	defer st.Close()
-	resp, err := st.Connect(ctx, &sshproxypb.ConnectRequest{
-		Services: proxyHosts,
-	})
+	watchDotenv(st)
+
+	nodes, hostEnvs, err := st.ConnectEnvs(ctx, envServices, dialTunnel)
 	if err != nil {
 		lg.Errorc(ctx, "Failed to connect remote services: %v", err)
 		return err
 	}
 
-	table := map[string][]*sshproxypb.Node{}
-
-	for _, node := range resp.GetConnectedNodes() {
-		table[tunnel.GetRemoteHost()] = append(table[tunnel.GetRemoteHost()], node)
+	if err := printNodes(nodes, hostEnvs); err != nil {
+		return err
 	}
-	lg.Info("Connected services\n" + prettyMaps(table))
 
 	srv := service.NewSuperService(
 		service.WithGRPC(func(srv *grpc.Server) {
 			sshproxypb.RegisterServiceTunnelServer(srv, st)
+			sshproxypb.RegisterMeshServiceServer(srv, server.NewMeshServer(serviceMesh, st, dialTunnel, isProtected))
 		}),
 		service.WithGRPCUI(),
 		service.WithPprof(),
@@ -212,5 +280,7 @@ func startConnect(proxyHosts []*sshproxypb.Service) error {
 func init() {
 	rootCmd.AddCommand(connectCmd)
 
-	connectCmd.Flags().StringP("user", "u", "root", "User to connect to remote services.")
+	connectCmd.Flags().StringP("user", "u", "", "User to connect to remote services, defaults.User or root if empty.")
+	connectCmd.Flags().Bool("direct", false, "Connect the ssh hosts given in the args, ignoring the current env")
+	connectCmd.Flags().String("file", "", "File of the services to connect, one per line, - for stdin")
 }
@@ -10,45 +10,62 @@ import (
 	"github.com/superwhys/goutils/flags"
 	"github.com/superwhys/goutils/lg"
 	"github.com/superwhys/ssh-proxy/server"
-	"github.com/superwhys/ssh-proxy/sshproxypb"
 )
 
 // connectmeshCmd represents the connectmesh command
 var connectmeshCmd = &cobra.Command{
-	Use:   "connect [mesh]",
-	Short: "Build tunnel to set of services",
-	Args:  cobra.ExactArgs(1),
+	Use:               "connect [--set key=value] [-l selector] [--only service] [--exclude service] [mesh]",
+	Short:             "Build tunnel to set of services",
+	ValidArgsFunction: completeMeshArg,
+	Args:              cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		set := flags.Slice("set", nil, "")
+		only := flags.Slice("only", nil, "")
+		exclude := flags.Slice("exclude", nil, "")
 		flags.Parse()
 
+		vars, err := parseVars(set())
+		if err != nil {
+			return err
+		}
+		// selector has a shorthand, which is only known by cobra
+		selectors, err := cmd.Flags().GetStringSlice("selector")
+		if err != nil {
+			return err
+		}
+		selector, err := server.ParseSelector(selectors...)
+		if err != nil {
+			return err
+		}
+
 		meshName := args[0]
-		meshUtils := server.NewServiceMesh()
+		meshUtils, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
 
-		mesh, err := meshUtils.GetMesh(meshName)
+		mesh, err := meshUtils.ExpandMesh(meshName)
 		if err != nil {
 			lg.Errorf("Failed to get mesh %s: %v", meshName, err)
 			return err
 		}
-
-		proxyHosts := make([]*sshproxypb.Service, 0, len(mesh.Services))
-		for _, service := range mesh.Services {
-			proxyHosts = append(proxyHosts, &sshproxypb.Service{
-				ServiceName:  service.ServiceName,
-				ProxyAddress: service.RemoteAddr,
-			})
+		mesh, err = mesh.Render(vars)
+		if err != nil {
+			lg.Errorf("Failed to render mesh %s: %v", meshName, err)
+			return err
 		}
+		mesh = mesh.Filter(selector, only(), exclude())
 
-		if len(proxyHosts) == 0 {
+		envServices := server.MeshEnvServices(mesh)
+
+		if len(envServices) == 0 {
 			lg.Errorf("No services found in mesh %s", meshName)
 			return nil
 		}
 
-		env = func() string {
-			return mesh.Env
-		}
-		lg.Infof("Starting connect to mesh %s, env %s", meshName, env())
+		lg.Infof("Starting connect to mesh %s, env %s", meshName, mesh.Env)
 
-		err = startConnect(proxyHosts)
+		err = startConnect(envServices)
 		if err != nil {
 			lg.Errorf("Failed to start connect: %v", err)
 			os.Exit(1)
@@ -59,4 +76,10 @@ var connectmeshCmd = &cobra.Command{
 
 func init() {
 	meshCmd.AddCommand(connectmeshCmd)
+	connectmeshCmd.Flags().StringSlice("set", nil, "Override the variables of the mesh, e.g. --set shard=42")
+	connectmeshCmd.Flags().StringSliceP("selector", "l", nil, "Only connect the services matched by the label selector, e.g. -l tier=db,team!=payments")
+	connectmeshCmd.Flags().StringSlice("only", nil, "Only connect the services of the names")
+	connectmeshCmd.Flags().StringSlice("exclude", nil, "Do not connect the services of the names")
+	connectmeshCmd.RegisterFlagCompletionFunc("only", completeMeshServiceFlag)
+	connectmeshCmd.RegisterFlagCompletionFunc("exclude", completeMeshServiceFlag)
 }
@@ -0,0 +1,43 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// contextCmd represents the context command
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Show the current env selected by ssh-proxy use",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+
+		envName := currentEnv()
+		if envName == "" {
+			lg.Info("No current env, select one with ssh-proxy use")
+			return nil
+		}
+
+		profile, err := getDialProfile(envName)
+		if err != nil {
+			return err
+		}
+		if profile.Protected {
+			fmt.Fprintf(stdout, "%s (protected): %s\n", envName, hostChain(profile))
+		} else {
+			fmt.Fprintf(stdout, "%s: %s\n", envName, hostChain(profile))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(contextCmd)
+}
@@ -12,29 +12,55 @@ import (
 
 // createmeshCmd represents the createmesh command
 var createmeshCmd = &cobra.Command{
-	Use:   "create --env dev [mesh] [service1] [service2] ...",
+	Use:   "create --env dev [--var key=value] [--description text] [--file services.txt] [mesh] [service1] [service2] ...",
 	Short: "Create a mesh of multiple services",
-	Args:  cobra.MinimumNArgs(2),
+	Args:  cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		varPairs := flags.Slice("var", nil, "")
+		description := flags.String("description", "", "")
+		file := flags.String("file", "", "")
 		flags.Parse()
 		meshName := args[0]
+		serviceArgs, err := appendServiceFile(args[1:], file())
+		if err != nil {
+			return err
+		}
+		if len(serviceArgs) == 0 {
+			return errors.New("no services provide, add them as args or --file services.txt")
+		}
+
+		vars, err := parseVars(varPairs())
+		if err != nil {
+			return err
+		}
 
-		if env() == "" {
-			return errors.New("no env provide")
+		envName := currentEnv()
+		if envName == "" {
+			return errors.New("no env provide, use --env or ssh-proxy use")
 		}
 
-		services, err := parseProfileHostPort(args[1:]...)
+		// the profile may not exist yet, e.g. the env contains variables
+		profile, _ := getProfile(envName)
+		services, err := parseProfileHostPort(profile, serviceArgs...)
 		if err != nil {
 			return err
 		}
 
-		serviceMesh := server.NewServiceMesh()
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
 		mesh := server.Mesh{
-			Name: meshName,
-			Env:  env(),
+			Name:        meshName,
+			Env:         envName,
+			Owner:       server.CurrentUser(),
+			Description: description(),
+		}
+		if len(vars) > 0 {
+			mesh.Vars = vars
 		}
 		for _, service := range services {
-			mesh.Services = append(mesh.Services, server.Service{RemoteAddr: service.ProxyAddress, ServiceName: service.ServiceName})
+			mesh.Services = append(mesh.Services, server.Service{RemoteAddr: service.ProxyAddress, ServiceName: service.ServiceName, Labels: service.Labels})
 		}
 
 		return serviceMesh.CreateMesh(mesh)
@@ -43,14 +69,7 @@ var createmeshCmd = &cobra.Command{
 
 func init() {
 	meshCmd.AddCommand(createmeshCmd)
-
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// createmeshCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// createmeshCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	createmeshCmd.Flags().StringSlice("var", nil, "Default value of the variables used in the env and services, e.g. --var shard=1")
+	createmeshCmd.Flags().String("description", "", "Description of the mesh")
+	createmeshCmd.Flags().String("file", "", "File of the services of the mesh, one per line, - for stdin")
 }
@@ -5,21 +5,26 @@ package cmd
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
 	"github.com/superwhys/goutils/lg"
-	"github.com/superwhys/ssh-proxy/server"
 )
 
 // deletemeshCmd represents the deletemesh command
 var deletemeshCmd = &cobra.Command{
-	Use:   "delete [mesh1] [mesh2] ...",
-	Short: "Delete mesh",
-	Args:  cobra.MinimumNArgs(1),
+	Use:               "delete [mesh1] [mesh2] ...",
+	Short:             "Delete mesh",
+	ValidArgsFunction: completeMeshes,
+	Args:              cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
 			return cmd.Help()
 		}
+		flags.Parse()
 
-		serviceMesh := server.NewServiceMesh()
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
 		for _, mesh := range args {
 			if err := serviceMesh.DeleteMesh(mesh); err != nil {
 				return err
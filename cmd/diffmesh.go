@@ -0,0 +1,123 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+var changeSigns = map[server.ChangeAction]string{
+	server.ChangeAdd:    "+",
+	server.ChangeRemove: "-",
+	server.ChangeUpdate: "~",
+}
+
+func formatService(service *server.Service) string {
+	s := fmt.Sprintf("%s (%s", service.ServiceName, service.RemoteAddr)
+	if service.Env != "" {
+		s += ", env " + service.Env
+	}
+	if len(service.Labels) > 0 {
+		s += ", " + server.FormatLabels(service.Labels)
+	}
+	return s + ")"
+}
+
+// prettyMeshChanges renders the changes like a diff,
+// + for added, - for removed and ~ for changed
+func prettyMeshChanges(changes []server.MeshChange) string {
+	if len(changes) == 0 {
+		return "No changes, the meshes are up to date\n"
+	}
+
+	var b strings.Builder
+	var added, removed, changed int
+	for _, change := range changes {
+		fmt.Fprintf(&b, "%s mesh %s\n", changeSigns[change.Action], change.Name)
+		for _, field := range change.Fields {
+			fmt.Fprintf(&b, "    %s: %q -> %q\n", field.Field, field.Old, field.New)
+		}
+		for _, sc := range change.Services {
+			switch sc.Action {
+			case server.ChangeAdd:
+				fmt.Fprintf(&b, "    + service %s\n", formatService(sc.New))
+			case server.ChangeRemove:
+				fmt.Fprintf(&b, "    - service %s\n", formatService(sc.Old))
+			case server.ChangeUpdate:
+				fmt.Fprintf(&b, "    ~ service %s -> %s\n", formatService(sc.Old), formatService(sc.New))
+			}
+		}
+
+		switch change.Action {
+		case server.ChangeAdd:
+			added++
+		case server.ChangeRemove:
+			removed++
+		case server.ChangeUpdate:
+			changed++
+		}
+	}
+	fmt.Fprintf(&b, "Plan: %d to add, %d to change, %d to remove\n", added, changed, removed)
+	return b.String()
+}
+
+// readDesiredMeshes reads and validates the meshes declared in the file
+func readDesiredMeshes(file string) ([]server.Mesh, error) {
+	if file == "" {
+		return nil, errors.New("no mesh file provide, use --file meshes.yaml")
+	}
+	b, err := readFileOrStdin(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read meshes")
+	}
+	doc, err := decodeMeshDocument(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMeshes(doc.Meshes); err != nil {
+		return nil, err
+	}
+	return doc.Meshes, nil
+}
+
+// diffmeshCmd represents the diffmesh command
+var diffmeshCmd = &cobra.Command{
+	Use:   "diff --file meshes.yaml [--prune]",
+	Short: "Show the changes mesh apply would make",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := flags.String("file", "", "")
+		prune := flags.Bool("prune", false, "")
+		flags.Parse()
+
+		desired, err := readDesiredMeshes(file())
+		if err != nil {
+			return err
+		}
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+		current, err := serviceMesh.GetAllMeshes()
+		if err != nil {
+			return err
+		}
+
+		lg.Infof("Mesh plan: \n%s", prettyMeshChanges(server.DiffMeshes(current, desired, prune())))
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(diffmeshCmd)
+	diffmeshCmd.Flags().String("file", "", "File of the desired meshes, - for stdin")
+	diffmeshCmd.Flags().Bool("prune", false, "Also show the meshes not in the file to be removed")
+}
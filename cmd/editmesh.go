@@ -0,0 +1,100 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+	"gopkg.in/yaml.v3"
+)
+
+func runEditor(path string) error {
+	// EDITOR may contain arguments, e.g. "code --wait"
+	fields := strings.Fields(os.Getenv("EDITOR"))
+	if len(fields) == 0 {
+		fields = []string{"vi"}
+	}
+	editorCmd := exec.Command(fields[0], append(fields[1:], path)...)
+	editorCmd.Stdin = os.Stdin
+	editorCmd.Stdout = stdout
+	editorCmd.Stderr = os.Stderr
+
+	return editorCmd.Run()
+}
+
+// editmeshCmd represents the editmesh command
+var editmeshCmd = &cobra.Command{
+	Use:               "edit [mesh]",
+	Short:             "Edit a mesh in $EDITOR",
+	ValidArgsFunction: completeMeshArg,
+	Args:              cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+		meshName := args[0]
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+		mesh, err := serviceMesh.GetMesh(meshName)
+		if err != nil {
+			return err
+		}
+
+		origin, err := yaml.Marshal(mesh)
+		if err != nil {
+			return errors.Wrap(err, "marshal mesh")
+		}
+		tmp, err := os.CreateTemp("", "ssh-proxy-mesh-*.yaml")
+		if err != nil {
+			return errors.Wrap(err, "create temp file")
+		}
+		tmp.Close()
+		if err := os.WriteFile(tmp.Name(), origin, 0600); err != nil {
+			return errors.Wrap(err, "write temp file")
+		}
+
+		if err := runEditor(tmp.Name()); err != nil {
+			os.Remove(tmp.Name())
+			return errors.Wrap(err, "run editor")
+		}
+
+		edited, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return errors.Wrap(err, "read temp file")
+		}
+		if string(edited) == string(origin) {
+			os.Remove(tmp.Name())
+			lg.Infof("Mesh %s not changed", meshName)
+			return nil
+		}
+
+		// keep the temp file on invalid content so that the changes are not lost
+		newMesh := server.Mesh{}
+		if err := yaml.Unmarshal(edited, &newMesh); err != nil {
+			return errors.Wrapf(err, "parse edited mesh, the changes are kept in %s", tmp.Name())
+		}
+		if err := validateMeshes([]server.Mesh{newMesh}); err != nil {
+			return errors.Wrapf(err, "invalid mesh, the changes are kept in %s", tmp.Name())
+		}
+		if err := serviceMesh.UpdateMesh(meshName, newMesh); err != nil {
+			return errors.Wrapf(err, "save mesh, the changes are kept in %s", tmp.Name())
+		}
+
+		os.Remove(tmp.Name())
+		lg.Infof("Mesh %s saved", newMesh.Name)
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(editmeshCmd)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// dialInstance connects to the gRPC API of the running ssh-proxy listening on --port
+func dialInstance() (*grpc.ClientConn, error) {
+	if port() == 0 {
+		return nil, errors.New("no port of the running ssh-proxy, start it with --port and pass the same --port")
+	}
+	conn, err := grpc.Dial(fmt.Sprintf("127.0.0.1:%d", port()), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, errors.Wrap(err, "dial running ssh-proxy")
+	}
+	return conn, nil
+}
+
+// watchDotenv keeps the dotenv file of --dotenv updated with the connected nodes
+func watchDotenv(st *server.ServiceTunnel) {
+	if dotenv() == "" {
+		return
+	}
+
+	st.OnNodesChange(func(nodes []*sshproxypb.Node) {
+		if err := server.UpdateDotenv(dotenv(), server.NodeEnvVars(envFormat(), nodes)); err != nil {
+			lg.Errorf("Failed to update dotenv file %s: %v", dotenv(), err)
+			return
+		}
+		lg.Infof("Dotenv file %s updated", dotenv())
+	})
+}
+
+// envCmd represents the env command
+var envCmd = &cobra.Command{
+	Use:   "env [--port port] [-l selector] [--envFormat %s_ADDR] [--dotenv file]",
+	Short: "Print the addresses of the connected services as env vars",
+	Long: `Print the addresses of the connected services of the running ssh-proxy as env vars,
+	so they can be loaded by the shell like:
+
+	eval $(ssh-proxy env --port 8080)
+
+	the env var names are derived from the service names by --envFormat, e.g. redis is REDIS_ADDR
+	`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+
+		// selector has a shorthand, which is only known by cobra
+		selectors, err := cmd.Flags().GetStringSlice("selector")
+		if err != nil {
+			return err
+		}
+
+		conn, err := dialInstance()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		resp, err := sshproxypb.NewServiceTunnelClient(conn).GetConnectNodes(context.Background(), &sshproxypb.GetConnectNodesRequest{
+			Selector: strings.Join(selectors, ","),
+		})
+		if err != nil {
+			return errors.Wrap(err, "get connected nodes")
+		}
+
+		vars := server.NodeEnvVars(envFormat(), resp.GetConnectedNodes())
+		if dotenv() != "" {
+			if err := server.UpdateDotenv(dotenv(), vars); err != nil {
+				return err
+			}
+			lg.Infof("Dotenv file %s updated", dotenv())
+			return nil
+		}
+
+		fmt.Fprint(stdout, server.FormatEnvVars(vars, true))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+	envCmd.Flags().StringSliceP("selector", "l", nil, "Only print the services matched by the label selector")
+}
@@ -0,0 +1,204 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+)
+
+// missingServices returns the names of the services which are not connected
+func missingServices(envServices map[string][]*sshproxypb.Service, nodes []*sshproxypb.Node) []string {
+	connected := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		connected[node.GetHostAddress()+"/"+node.GetRemoteAddress()] = true
+	}
+
+	var missing []string
+	for _, services := range envServices {
+		for _, service := range services {
+			if !connected[service.GetRemoteAddress()+"/"+service.GetProxyAddress()] {
+				missing = append(missing, service.GetServiceName())
+			}
+		}
+	}
+	return missing
+}
+
+// exitCode returns the exit code of the finished command like the shell,
+// 128+signal if it is killed by a signal
+func exitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, err
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return exitErr.ExitCode(), nil
+}
+
+// execWithTunnels connects the services, runs the command with their addresses
+// and closes the tunnels once the command exits, it returns the exit code of the command
+func execWithTunnels(envServices map[string][]*sshproxypb.Service, command []string) (int, error) {
+	ctx := context.Background()
+	if err := confirmEnvs(envServices); err != nil {
+		return 0, err
+	}
+
+	st := server.NewServiceTunnel()
+	defer st.Close()
+	watchDotenv(st)
+
+	nodes, _, err := st.ConnectEnvs(ctx, envServices, dialTunnel)
+	if err != nil {
+		return 0, err
+	}
+	// the local listeners are ready once connected, only the failed ones are missing
+	if missing := missingServices(envServices, nodes); len(missing) > 0 {
+		return 0, fmt.Errorf("failed to connect services: %s", strings.Join(missing, ", "))
+	}
+
+	vars := server.NodeEnvVars(envFormat(), nodes)
+	args, err := server.RenderArgs(command, vars)
+	if err != nil {
+		return 0, err
+	}
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, stdout, os.Stderr
+	c.Env = os.Environ()
+	for name, value := range vars {
+		c.Env = append(c.Env, name+"="+value)
+	}
+
+	// the signals are passed to the command, the tunnels are closed after it exits
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	lg.Infof("Running %s", strings.Join(args, " "))
+	if err := c.Start(); err != nil {
+		return 0, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				c.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return exitCode(c.Wait())
+}
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:               "exec [--env env | --mesh mesh] [service...] -- command [args...]",
+	Short:             "Run a command with the tunnels of the services and close them after it exits",
+	ValidArgsFunction: completeProfileServices,
+	Long: `Run a command with the tunnels of the services and close them after it exits.
+	The addresses of the services are provided to the command as env vars named by --envFormat,
+	and can be substituted into the args like:
+
+	ssh-proxy exec --env dev redis -- redis-cli -u 'redis://{{.REDIS_ADDR}}/0' ping
+
+	the exit code of the command is the exit code of ssh-proxy
+	`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if cmd.ArgsLenAtDash() < 0 || cmd.ArgsLenAtDash() == len(args) {
+			return errors.New("no command provide, put the command after --")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		meshName := flags.String("mesh", "", "")
+		set := flags.Slice("set", nil, "")
+		flags.Parse()
+
+		services, command := args[:cmd.ArgsLenAtDash()], args[cmd.ArgsLenAtDash():]
+
+		var envServices map[string][]*sshproxypb.Service
+		if meshName() != "" {
+			vars, err := parseVars(set())
+			if err != nil {
+				return err
+			}
+			meshUtils, err := newServiceMesh()
+			if err != nil {
+				return err
+			}
+			mesh, err := meshUtils.ExpandMesh(meshName())
+			if err != nil {
+				return err
+			}
+			if mesh, err = mesh.Render(vars); err != nil {
+				return err
+			}
+			envServices = server.MeshEnvServices(mesh.Filter(nil, services, nil))
+		} else {
+			envName := currentEnv()
+			if envName == "" {
+				return errors.New("no env or mesh provide, use --env, --mesh or ssh-proxy use")
+			}
+			profile, err := getProfile(envName)
+			if err != nil {
+				return err
+			}
+			proxyHosts, err := parseProfileHostPort(profile, services...)
+			if err != nil {
+				return err
+			}
+			if err := checkRendered(proxyHosts); err != nil {
+				return err
+			}
+			if len(proxyHosts) == 0 {
+				return errors.New("no services to connect")
+			}
+			envServices = map[string][]*sshproxypb.Service{envName: proxyHosts}
+		}
+
+		if len(envServices) == 0 {
+			return errors.New("no services to connect")
+		}
+
+		code, err := execWithTunnels(envServices, command)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			os.Exit(code)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	execCmd.Flags().String("mesh", "", "Connect the services of the mesh, the given services only if any")
+	execCmd.RegisterFlagCompletionFunc("mesh", completeMeshes)
+	execCmd.Flags().StringSlice("set", nil, "Override the variables of the mesh, e.g. --set shard=42")
+}
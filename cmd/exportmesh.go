@@ -0,0 +1,168 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+	"gopkg.in/yaml.v3"
+)
+
+// meshDocument is the format used to share meshes between users
+type meshDocument struct {
+	Meshes []server.Mesh `yaml:"meshes"`
+	// Profiles are the connection profiles of the meshes env,
+	// which are only embedded if requested on export
+	Profiles []*ConnectionProfile `yaml:"profiles,omitempty" json:",omitempty"`
+}
+
+func encodeMeshDocument(doc *meshDocument, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(doc, "", "  ")
+	case "yaml":
+		return yaml.Marshal(doc)
+	default:
+		return nil, fmt.Errorf("unknown format: %v", format)
+	}
+}
+
+// decodeMeshDocument decodes both formats produced by encodeMeshDocument
+func decodeMeshDocument(b []byte) (*meshDocument, error) {
+	doc := &meshDocument{}
+
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, doc); err != nil {
+			return nil, errors.Wrap(err, "parse json error")
+		}
+		return doc, nil
+	}
+
+	if err := yaml.Unmarshal(b, doc); err != nil {
+		return nil, errors.Wrap(err, "parse yaml error")
+	}
+	return doc, nil
+}
+
+// meshEnvs returns the envs needed to connect the mesh,
+// which are the env of the mesh and the envs of the services of it and its includes
+func meshEnvs(serviceMesh *server.ServiceMesh, mesh server.Mesh) []string {
+	expanded, err := serviceMesh.ExpandMesh(mesh.Name)
+	if err != nil {
+		lg.Warnf("mesh %s: %v, only the envs of its own services are exported", mesh.Name, err)
+		expanded = &mesh
+	}
+
+	envs := []string{expanded.Env}
+	for env := range expanded.GroupServicesByEnv() {
+		if env != expanded.Env {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs[1:])
+	return envs
+}
+
+// exportProfiles returns the profiles of the envs needed by the meshes,
+// the identity files are paths of this machine, so they are not exported
+func exportProfiles(serviceMesh *server.ServiceMesh, meshes []server.Mesh) []*ConnectionProfile {
+	var profiles []*ConnectionProfile
+	envs := make(map[string]bool)
+	stripped := false
+	for _, mesh := range meshes {
+		for _, env := range meshEnvs(serviceMesh, mesh) {
+			if envs[env] {
+				continue
+			}
+			envs[env] = true
+
+			profile, err := getProfile(env)
+			if err != nil {
+				lg.Warnf("mesh %s: %v, skip embedding its profile", mesh.Name, err)
+				continue
+			}
+			for _, h := range profile.Hosts {
+				if h.IdentityFile != "" {
+					h.IdentityFile = ""
+					stripped = true
+				}
+			}
+			profiles = append(profiles, profile)
+		}
+	}
+	if stripped {
+		lg.Infof("The identity files of the profiles are not exported, the importers use their own keys")
+	}
+	return profiles
+}
+
+// exportmeshCmd represents the exportmesh command
+var exportmeshCmd = &cobra.Command{
+	Use:               "export [--format yaml|json] [--with-profile] [--file path] [mesh1] [mesh2] ...",
+	Short:             "Export meshes, all meshes are exported if no mesh provided",
+	ValidArgsFunction: completeMeshes,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format := flags.String("format", "yaml", "")
+		withProfile := flags.Bool("with-profile", false, "")
+		file := flags.String("file", "", "")
+		flags.Parse()
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+
+		doc := &meshDocument{}
+		if len(args) == 0 {
+			doc.Meshes, err = serviceMesh.GetAllMeshes()
+			if err != nil {
+				return err
+			}
+		} else {
+			for _, name := range args {
+				mesh, err := serviceMesh.GetMesh(name)
+				if err != nil {
+					return errors.Wrapf(err, "get mesh %s", name)
+				}
+				doc.Meshes = append(doc.Meshes, *mesh)
+			}
+		}
+
+		if withProfile() {
+			doc.Profiles = exportProfiles(serviceMesh, doc.Meshes)
+		}
+
+		b, err := encodeMeshDocument(doc, format())
+		if err != nil {
+			return err
+		}
+
+		if file() == "" {
+			_, err = stdout.Write(b)
+			return err
+		}
+		if err := os.WriteFile(file(), b, 0600); err != nil {
+			return errors.Wrap(err, "write export file")
+		}
+		lg.Infof("%d meshes exported to %s", len(doc.Meshes), file())
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(exportmeshCmd)
+	exportmeshCmd.Flags().String("format", "yaml", "Output format, yaml or json")
+	exportmeshCmd.Flags().Bool("with-profile", false, "Embed the connection profiles of the envs of the meshes and their includes, without the identity files")
+	exportmeshCmd.Flags().String("file", "", "Write to the file instead of stdout")
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+func TestExportProfiles(t *testing.T) {
+	flags.Viper().Set("profiles", []map[string]interface{}{
+		{"EnvName": "dev", "Hosts": []map[string]interface{}{{"HostName": "10.0.0.1", "IdentityFile": "~/.ssh/id_dev"}}},
+		{"EnvName": "staging", "Hosts": []map[string]interface{}{{"HostName": "10.0.1.1"}}},
+		{"EnvName": "infra", "Extends": "dev", "Hosts": []map[string]interface{}{{"HostName": "10.0.2.1", "User": "ops"}}},
+	})
+	t.Cleanup(func() { flags.Viper().Set("profiles", []map[string]interface{}{}) })
+
+	serviceMesh := server.NewServiceMesh(server.NewMemoryMeshStore(
+		server.Mesh{Name: "product-a", Env: "dev", Includes: []string{"infra-base"}, Services: []server.Service{
+			{ServiceName: "api", RemoteAddr: "api:8080"},
+			{ServiceName: "auth", RemoteAddr: "auth:443", Env: "staging"},
+		}},
+		server.Mesh{Name: "infra-base", Env: "infra", Services: []server.Service{{ServiceName: "redis", RemoteAddr: "redis:6379"}}},
+		server.Mesh{Name: "unknown", Env: "qa", Services: []server.Service{{ServiceName: "web", RemoteAddr: "web:80"}}},
+	))
+
+	tests := []struct {
+		meshes []string
+		envs   []string
+	}{
+		{[]string{"product-a"}, []string{"dev", "infra", "staging"}},
+		{[]string{"infra-base", "product-a"}, []string{"infra", "dev", "staging"}},
+		// the profile of qa is not found and skipped
+		{[]string{"unknown"}, nil},
+	}
+	for _, tt := range tests {
+		var meshes []server.Mesh
+		for _, name := range tt.meshes {
+			mesh, err := serviceMesh.GetMesh(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			meshes = append(meshes, *mesh)
+		}
+
+		var envs []string
+		for _, profile := range exportProfiles(serviceMesh, meshes) {
+			envs = append(envs, profile.EnvName)
+			for _, h := range profile.Hosts {
+				if h.IdentityFile != "" {
+					t.Errorf("exportProfiles(%v) profile %s host %s has identity file %s, want it stripped", tt.meshes, profile.EnvName, h.HostName, h.IdentityFile)
+				}
+			}
+		}
+		if !reflect.DeepEqual(envs, tt.envs) {
+			t.Errorf("exportProfiles(%v) envs = %v, want %v", tt.meshes, envs, tt.envs)
+		}
+	}
+}
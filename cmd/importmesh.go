@@ -0,0 +1,104 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+func readFileOrStdin(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// appendServiceFile appends the services in the file of --file to the args,
+// the file lists one service per line and - is stdin
+func appendServiceFile(args []string, file string) ([]string, error) {
+	if file == "" {
+		return args, nil
+	}
+	b, err := readFileOrStdin(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "read service file")
+	}
+	return append(args, server.ParseServiceList(b)...), nil
+}
+
+// validateMeshes checks meshes from outside before saving them
+func validateMeshes(meshes []server.Mesh) error {
+	for _, mesh := range meshes {
+		if err := mesh.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importmeshCmd represents the importmesh command
+var importmeshCmd = &cobra.Command{
+	Use:   "import [--on-conflict skip|overwrite|rename] [file|-]",
+	Short: "Import meshes from a file or stdin exported by mesh export",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		onConflict := flags.String("on-conflict", string(server.ConflictSkip), "")
+		flags.Parse()
+
+		b, err := readFileOrStdin(args[0])
+		if err != nil {
+			return errors.Wrap(err, "read meshes")
+		}
+		doc, err := decodeMeshDocument(b)
+		if err != nil {
+			return err
+		}
+		if err := validateMeshes(doc.Meshes); err != nil {
+			return err
+		}
+
+		owner := server.CurrentUser()
+		for i := range doc.Meshes {
+			doc.Meshes[i].Owner = owner
+		}
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+		results, err := serviceMesh.ImportMeshes(doc.Meshes, server.ConflictPolicy(onConflict()))
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if r.ImportedAs != "" && r.ImportedAs != r.Name {
+				lg.Infof("Mesh %s %s as %s", r.Name, r.Action, r.ImportedAs)
+			} else {
+				lg.Infof("Mesh %s %s", r.Name, r.Action)
+			}
+		}
+
+		for _, profile := range doc.Profiles {
+			if _, err := getProfile(profile.EnvName); err == nil {
+				continue
+			}
+			lg.Warnf("env %s is not in your profiles, add it to your config to connect:\n%s", profile.EnvName, lg.Jsonify(profile))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(importmeshCmd)
+	importmeshCmd.Flags().String("on-conflict", string(server.ConflictSkip), "What to do if a mesh already exists, skip, overwrite or rename")
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// includemeshCmd represents the includemesh command
+var includemeshCmd = &cobra.Command{
+	Use:               "include [--remove] [mesh] [include1] [include2] ...",
+	Short:             "Include other meshes, their services are connected with the mesh",
+	ValidArgsFunction: completeMeshes,
+	Args:              cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		remove := flags.Bool("remove", false, "")
+		flags.Parse()
+		meshName := args[0]
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+
+		if remove() {
+			if err := serviceMesh.RemoveIncludes(meshName, args[1:]...); err != nil {
+				return err
+			}
+			lg.Infof("Mesh %s no longer includes %v", meshName, args[1:])
+			return nil
+		}
+
+		if err := serviceMesh.IncludeMeshes(meshName, args[1:]...); err != nil {
+			return err
+		}
+		lg.Infof("Mesh %s includes %v", meshName, args[1:])
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(includemeshCmd)
+	includemeshCmd.Flags().Bool("remove", false, "Remove the includes instead of adding")
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"reflect"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/sshtunnel"
+	"golang.org/x/crypto/ssh"
+)
+
+// tunnelClient returns the current ssh client of the tunnel, which sshtunnel does not expose
+// and replaces when it reconnects, so it is read again before every keepalive request
+func tunnelClient(tunnel *sshtunnel.SshTunnel) *ssh.Client {
+	field := reflect.ValueOf(tunnel).Elem().FieldByName("sshClient")
+	if !field.IsValid() || field.Type() != reflect.TypeOf((*ssh.Client)(nil)) {
+		return nil
+	}
+	return (*ssh.Client)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(field.UnsafeAddr()))))
+}
+
+// keepAlive sends keepalive requests to the ssh host of the tunnel every interval,
+// on top of the ones sshtunnel sends every 15s. The failed requests are left to sshtunnel,
+// which reconnects the tunnel, and the loop lives as long as the keepalive of sshtunnel
+func keepAlive(tunnel *sshtunnel.SshTunnel, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	if tunnelClient(tunnel) == nil {
+		lg.Warnf("Keepalive of %s is not supported by this sshtunnel version", tunnel.GetRemoteHost())
+		return
+	}
+
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		for range tick.C {
+			client := tunnelClient(tunnel)
+			if client == nil {
+				continue
+			}
+			if _, _, err := client.SendRequest("keepalive@golang.org", true, nil); err != nil {
+				lg.Debugf("Keepalive of %s: %v", tunnel.GetRemoteHost(), err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,53 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+// labelmeshCmd represents the labelmesh command
+var labelmeshCmd = &cobra.Command{
+	Use:               "label [mesh] [service] [key=value] [key-] ...",
+	Short:             "Set labels on a service of the mesh, key- removes the label",
+	ValidArgsFunction: completeMeshArg,
+	Args:              cobra.MinimumNArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+		meshName, serviceName := args[0], args[1]
+
+		var pairs, removes []string
+		for _, arg := range args[2:] {
+			if strings.HasSuffix(arg, "-") && !strings.Contains(arg, "=") {
+				removes = append(removes, strings.TrimSuffix(arg, "-"))
+				continue
+			}
+			pairs = append(pairs, arg)
+		}
+		labels, err := server.ParseLabels(pairs...)
+		if err != nil {
+			return err
+		}
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+		if err := serviceMesh.LabelService(meshName, serviceName, labels, removes); err != nil {
+			return err
+		}
+		lg.Infof("Service %s in mesh %s labeled", serviceName, meshName)
+
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(labelmeshCmd)
+}
@@ -4,55 +4,105 @@ Copyright © 2023 Yong
 package cmd
 
 import (
-	"errors"
-	"fmt"
+	"bytes"
+	"strconv"
+	"strings"
 
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 	"github.com/superwhys/goutils/flags"
 	"github.com/superwhys/goutils/lg"
 	"github.com/superwhys/ssh-proxy/server"
 )
 
+func prettyMeshes(meshes []server.Mesh) string {
+	buffer := &bytes.Buffer{}
+	table := tablewriter.NewWriter(buffer)
+	table.Append([]string{"Name", "Env", "Owner", "Services", "Includes", "Description", "Updated"})
+	for _, mesh := range meshes {
+		updated := ""
+		if !mesh.UpdatedAt.IsZero() {
+			updated = mesh.UpdatedAt.Local().Format("2006-01-02 15:04")
+		}
+		table.Append([]string{
+			mesh.Name,
+			mesh.Env,
+			mesh.Owner,
+			strconv.Itoa(len(mesh.Services)),
+			strings.Join(mesh.Includes, ","),
+			mesh.Description,
+			updated,
+		})
+	}
+	table.Render()
+	return buffer.String()
+}
+
+func prettyMeshServices(mesh *server.Mesh) string {
+	buffer := &bytes.Buffer{}
+	table := tablewriter.NewWriter(buffer)
+	table.Append([]string{"Service", "Remote Address", "Env", "Labels"})
+	for _, service := range mesh.Services {
+		table.Append([]string{service.ServiceName, service.RemoteAddr, mesh.GetEnv(service), server.FormatLabels(service.Labels)})
+	}
+	table.Render()
+	return buffer.String()
+}
+
 // listmeshCmd represents the listmesh command
 var listmeshCmd = &cobra.Command{
-	Use:                   "ls [--all]|[mesh]",
-	Short:                 "List all mesh services",
+	Use:                   "ls [--all] [--expand] [mesh]",
+	Short:                 "List the meshes of current user, or the services of a mesh",
+	ValidArgsFunction:     completeMeshArg,
 	DisableFlagsInUseLine: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		all := flags.Bool("all", false, "")
+		expand := flags.Bool("expand", false, "")
 		flags.Parse()
 
-		serviceMesh := server.NewServiceMesh()
-
-		var showLines []string
-		if all() {
-			meshes, err := serviceMesh.GetAllMeshes()
-			if err != nil {
-				return err
-			}
-			for _, mesh := range meshes {
-				showLines = append(showLines, mesh.Name)
-			}
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
 
-		} else {
-			if len(args) == 0 {
-				return errors.New("mesh name is required")
+		if len(args) == 0 {
+			var meshes []server.Mesh
+			if all() {
+				meshes, err = serviceMesh.GetAllMeshes()
+			} else {
+				meshes, err = serviceMesh.GetUserMeshes(server.CurrentUser())
 			}
-
-			mesh, err := serviceMesh.GetMesh(args[0])
 			if err != nil {
 				return err
 			}
-			for _, service := range mesh.Services {
-				showLines = append(showLines, fmt.Sprintf("%s \n %s", service.ServiceName, service.RemoteAddr))
+			if meshes == nil {
+				// keep the json output an array
+				meshes = []server.Mesh{}
 			}
+			return printOutput(meshes, func() string {
+				return prettyMeshes(meshes)
+			})
+		}
+
+		getMesh := serviceMesh.GetMesh
+		if expand() {
+			getMesh = serviceMesh.ExpandMesh
+		}
+		mesh, err := getMesh(args[0])
+		if err != nil {
+			return err
+		}
+		if len(mesh.Includes) > 0 && !expand() {
+			lg.Infof("Mesh %s includes %v, use --expand to show their services", mesh.Name, mesh.Includes)
 		}
-		lg.Infof("All mesh services: \n %s", prettySlices(showLines))
-		return nil
+		return printOutput(mesh, func() string {
+			return prettyMeshServices(mesh)
+		})
 	},
 }
 
 func init() {
 	meshCmd.AddCommand(listmeshCmd)
-	listmeshCmd.Flags().Bool("all", false, "Include mesh not only belongs to current user")
+	listmeshCmd.Flags().Bool("all", false, "List the meshes of all users instead of the current user, useful for a shared mesh store")
+	listmeshCmd.Flags().Bool("expand", false, "Show the services of the included meshes")
 }
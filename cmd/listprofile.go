@@ -0,0 +1,83 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// hostChain formats the hosts of the profile as "user@host -> user@host"
+func hostChain(profile *ConnectionProfile) string {
+	hops := make([]string, 0, len(profile.Hosts))
+	for _, h := range profile.Hosts {
+		hop := h.HostName
+		if h.User != "" {
+			hop = h.User + "@" + hop
+		}
+		hops = append(hops, hop)
+	}
+	return strings.Join(hops, " -> ")
+}
+
+// serviceNames returns the sorted names of the services of the profile
+func serviceNames(profile *ConnectionProfile) string {
+	names := make([]string, 0, len(profile.Services))
+	for name := range profile.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// prettyProfiles renders the profiles with the hosts and services of their parents
+func prettyProfiles(profiles []*ConnectionProfile) string {
+	buffer := &bytes.Buffer{}
+	table := tablewriter.NewWriter(buffer)
+	table.Append([]string{"Env", "Extends", "Protected", "Hosts", "Services"})
+	for _, profile := range profiles {
+		var protected, hosts, services string
+		if resolved, err := resolveProfile(profiles, profile.EnvName, nil); err != nil {
+			hosts = err.Error()
+		} else {
+			hosts = hostChain(resolved)
+			services = serviceNames(resolved)
+			if resolved.Protected {
+				protected = "yes"
+			}
+		}
+		table.Append([]string{profile.EnvName, profile.Extends, protected, hosts, services})
+	}
+	table.Render()
+	return buffer.String()
+}
+
+// listprofileCmd represents the listprofile command
+var listprofileCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the connection profiles",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+
+		allProfiles, err := getAllProfiles()
+		if err != nil {
+			return err
+		}
+		lg.Infof("Profiles in %s", configFilePath())
+		return printOutput(toProfileOutputs(allProfiles), func() string {
+			return prettyProfiles(allProfiles)
+		})
+	},
+}
+
+func init() {
+	profileCmd.AddCommand(listprofileCmd)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+// migrateState migrates the state dir on first run of a new version,
+// it is skipped by state migrate which reports the steps itself,
+// and by the shell completions which should not change anything
+func migrateState(cmd *cobra.Command) {
+	if cmd == migratestateCmd || cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+		return
+	}
+
+	steps, err := server.NewState(server.StateDir()).Migrate(false)
+	if err != nil {
+		lg.Warnf("Failed to migrate state: %v", err)
+		return
+	}
+	for _, step := range steps {
+		lg.Infof("State: %s", step)
+	}
+}
+
+// migratestateCmd represents the migratestate command
+var migratestateCmd = &cobra.Command{
+	Use:   "migrate [--dry-run]",
+	Short: "Migrate the legacy mesh store and port cache into the versioned state dir",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun := flags.Bool("dry-run", false, "")
+		flags.Parse()
+
+		state := server.NewState(server.StateDir())
+		version, err := state.Version()
+		if err != nil {
+			return err
+		}
+		lg.Infof("State dir: %s, version %d, latest version %d", state.Dir, version, server.StateVersion)
+
+		steps, err := state.Migrate(dryRun())
+		for _, step := range steps {
+			lg.Infof("State: %s", step)
+		}
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			lg.Info("State is up to date")
+		} else if dryRun() {
+			lg.Info("Dry run, nothing is changed")
+		}
+		return nil
+	},
+}
+
+func init() {
+	stateCmd.AddCommand(migratestateCmd)
+	migratestateCmd.Flags().Bool("dry-run", false, "Only show the migration steps")
+}
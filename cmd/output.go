@@ -0,0 +1,142 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	output = flags.String("output", "table", "Output format, table, json or yaml")
+
+	// stdout is the output of the commands, the logs written to stdout by lg
+	// are redirected to stderr by redirectLogs, so the output can be piped
+	stdout = os.Stdout
+)
+
+// printOutput prints v to stdout in the format of --output, table renders the table format
+func printOutput(v any, table func() string) error {
+	var b []byte
+	var err error
+	switch output() {
+	case "table":
+		b = []byte(table())
+	case "json":
+		b, err = json.MarshalIndent(v, "", "  ")
+		b = append(b, '\n')
+	case "yaml":
+		b, err = yaml.Marshal(v)
+	default:
+		return fmt.Errorf("unknown output: %v, it should be table, json or yaml", output())
+	}
+	if err != nil {
+		return err
+	}
+	_, err = stdout.Write(b)
+	return err
+}
+
+// nodeOutput is the json and yaml schema of a connected node
+type nodeOutput struct {
+	Env           string            `json:"env,omitempty" yaml:"env,omitempty"`
+	Host          string            `json:"host" yaml:"host"`
+	Service       string            `json:"service" yaml:"service"`
+	RemoteAddress string            `json:"remoteAddress" yaml:"remoteAddress"`
+	LocalAddress  string            `json:"localAddress" yaml:"localAddress"`
+	LocalPort     int               `json:"localPort" yaml:"localPort"`
+	Labels        map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+func toNodeOutputs(nodes []*sshproxypb.Node, hostEnvs map[string]string) []nodeOutput {
+	outputs := make([]nodeOutput, 0, len(nodes))
+	for _, node := range nodes {
+		_, port, _ := net.SplitHostPort(node.GetLocalAddress())
+		localPort, _ := strconv.Atoi(port)
+		labels, _ := server.ParseLabels(node.GetTag())
+		if len(labels) == 0 {
+			labels = nil
+		}
+		outputs = append(outputs, nodeOutput{
+			Env:           hostEnvs[node.GetHostAddress()],
+			Host:          node.GetHostAddress(),
+			Service:       node.GetServiceName(),
+			RemoteAddress: node.GetRemoteAddress(),
+			LocalAddress:  node.GetLocalAddress(),
+			LocalPort:     localPort,
+			Labels:        labels,
+		})
+	}
+	sort.SliceStable(outputs, func(i, j int) bool {
+		if outputs[i].Env != outputs[j].Env {
+			return outputs[i].Env < outputs[j].Env
+		}
+		if outputs[i].Host != outputs[j].Host {
+			return outputs[i].Host < outputs[j].Host
+		}
+		return outputs[i].Service < outputs[j].Service
+	})
+	return outputs
+}
+
+// printNodes prints the connected nodes, the table is grouped by host
+func printNodes(nodes []*sshproxypb.Node, hostEnvs map[string]string) error {
+	lg.Info("Connected services")
+	return printOutput(toNodeOutputs(nodes, hostEnvs), func() string {
+		table := map[string][]*sshproxypb.Node{}
+		for _, node := range nodes {
+			table[node.GetHostAddress()] = append(table[node.GetHostAddress()], node)
+		}
+		return prettyMaps(table, hostEnvs)
+	})
+}
+
+// profileOutput is the json and yaml schema of a connection profile in listings,
+// the hosts and services include the ones of the parents
+type profileOutput struct {
+	Env       string   `json:"env" yaml:"env"`
+	Extends   string   `json:"extends,omitempty" yaml:"extends,omitempty"`
+	Protected bool     `json:"protected" yaml:"protected"`
+	Hosts     []string `json:"hosts" yaml:"hosts"`
+	Services  []string `json:"services" yaml:"services"`
+	Error     string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func toProfileOutputs(profiles []*ConnectionProfile) []profileOutput {
+	outputs := make([]profileOutput, 0, len(profiles))
+	for _, profile := range profiles {
+		o := profileOutput{Env: profile.EnvName, Extends: profile.Extends, Hosts: []string{}, Services: []string{}}
+		if resolved, err := resolveProfile(profiles, profile.EnvName, nil); err != nil {
+			o.Error = err.Error()
+		} else {
+			o.Protected = resolved.Protected
+			if chain := hostChain(resolved); chain != "" {
+				o.Hosts = strings.Split(chain, " -> ")
+			}
+			if names := serviceNames(resolved); names != "" {
+				o.Services = strings.Split(names, ",")
+			}
+		}
+		outputs = append(outputs, o)
+	}
+	return outputs
+}
+
+// portOutput is the json and yaml schema of a cached local port
+type portOutput struct {
+	Via           string `json:"via,omitempty" yaml:"via,omitempty"`
+	RemoteAddress string `json:"remoteAddress" yaml:"remoteAddress"`
+	LocalPort     int    `json:"localPort" yaml:"localPort"`
+}
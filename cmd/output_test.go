@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+	"github.com/superwhys/sshtunnel"
+)
+
+func TestPrintNodes(t *testing.T) {
+	nodes := []*sshproxypb.Node{
+		{HostAddress: "bastion:22", ServiceName: "redis", RemoteAddress: "redis:6379", LocalAddress: "127.0.0.1:16379", Tag: "tier=db"},
+	}
+	hostEnvs := map[string]string{"bastion:22": "dev"}
+
+	tests := []struct {
+		output  string
+		want    string
+		wantErr bool
+	}{
+		{output: "json", want: `[
+  {
+    "env": "dev",
+    "host": "bastion:22",
+    "service": "redis",
+    "remoteAddress": "redis:6379",
+    "localAddress": "127.0.0.1:16379",
+    "localPort": 16379,
+    "labels": {
+      "tier": "db"
+    }
+  }
+]
+`},
+		{output: "yaml", want: `- env: dev
+  host: bastion:22
+  service: redis
+  remoteAddress: redis:6379
+  localAddress: 127.0.0.1:16379
+  localPort: 16379
+  labels:
+    tier: db
+`},
+		{output: "xml", wantErr: true},
+	}
+
+	origin := stdout
+	t.Cleanup(func() {
+		stdout = origin
+		flags.Viper().Set("output", "table")
+	})
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			stdout = f
+			flags.Viper().Set("output", tt.output)
+
+			if err := printNodes(nodes, hostEnvs); (err != nil) != tt.wantErr {
+				t.Fatalf("printNodes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			b, err := os.ReadFile(f.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("printNodes() with --output %s =\n%s\nwant\n%s", tt.output, b, tt.want)
+			}
+		})
+	}
+}
+
+func TestToProfileOutputs(t *testing.T) {
+	profiles := []*ConnectionProfile{
+		{EnvName: "bastion", Protected: true, Hosts: []*sshtunnel.SshConfig{{HostName: "bastion:22", User: "ops"}}},
+		{EnvName: "prod", Extends: "bastion", Hosts: []*sshtunnel.SshConfig{{HostName: "10.0.1.5"}}, Services: map[string]interface{}{"redis": "10.0.1.6:6379"}},
+		{EnvName: "loop", Extends: "loop"},
+	}
+	want := []profileOutput{
+		{Env: "bastion", Protected: true, Hosts: []string{"ops@bastion:22"}, Services: []string{}},
+		{Env: "prod", Extends: "bastion", Protected: true, Hosts: []string{"ops@bastion:22", "10.0.1.5"}, Services: []string{"redis"}},
+		{Env: "loop", Extends: "loop", Hosts: []string{}, Services: []string{}, Error: "profile extends cycle: loop -> loop"},
+	}
+	if got := toProfileOutputs(profiles); !reflect.DeepEqual(got, want) {
+		t.Errorf("toProfileOutputs() = %+v, want %+v", got, want)
+	}
+}
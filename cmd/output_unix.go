@@ -0,0 +1,49 @@
+//go:build !windows
+
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// redirectLogs points the fd of stdout to stderr, where the logs of lg go,
+// and keeps the original stdout for the output of the commands
+func redirectLogs() error {
+	fd, err := unix.Dup(int(os.Stdout.Fd()))
+	if err != nil {
+		return err
+	}
+	unix.CloseOnExec(fd)
+	if err := unix.Dup2(int(os.Stderr.Fd()), int(os.Stdout.Fd())); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	stdout = os.NewFile(uintptr(fd), "/dev/stdout")
+	return nil
+}
+
+// redirectLogsTo points the fds of stdout and stderr to the file,
+// so the logs do not mess up a terminal ui drawn on the original stdout.
+// It returns the original stderr
+func redirectLogsTo(f *os.File) (*os.File, error) {
+	fd, err := unix.Dup(int(os.Stderr.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	unix.CloseOnExec(fd)
+	stderr := os.NewFile(uintptr(fd), "/dev/stderr")
+	if err := unix.Dup2(int(f.Fd()), int(os.Stdout.Fd())); err != nil {
+		stderr.Close()
+		return nil, err
+	}
+	if err := unix.Dup2(int(f.Fd()), int(os.Stderr.Fd())); err != nil {
+		stderr.Close()
+		return nil, err
+	}
+	return stderr, nil
+}
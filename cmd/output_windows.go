@@ -0,0 +1,16 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import "os"
+
+// redirectLogs is not supported on windows, the logs stay on stdout
+func redirectLogs() error {
+	return nil
+}
+
+// redirectLogsTo is not supported on windows, the logs stay on stdout
+func redirectLogsTo(f *os.File) (*os.File, error) {
+	return os.Stderr, nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+func prettyPorts(ports []portOutput) string {
+	buffer := &bytes.Buffer{}
+	table := tablewriter.NewWriter(buffer)
+	table.Append([]string{"Via", "Remote Address", "Local Port"})
+	for _, p := range ports {
+		table.Append([]string{p.Via, p.RemoteAddress, strconv.Itoa(p.LocalPort)})
+	}
+	table.Render()
+	return buffer.String()
+}
+
+// portsCmd represents the ports command
+var portsCmd = &cobra.Command{
+	Use:   "ports",
+	Short: "List the cached local ports of the remote services",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+
+		cache, err := server.LocalPorts()
+		if err != nil {
+			return err
+		}
+
+		ports := make([]portOutput, 0, len(cache))
+		for key, localPort := range cache {
+			port, _ := strconv.Atoi(localPort)
+			via, remoteAddr := server.SplitPortCacheKey(key)
+			ports = append(ports, portOutput{Via: via, RemoteAddress: remoteAddr, LocalPort: port})
+		}
+		sort.Slice(ports, func(i, j int) bool {
+			if ports[i].Via != ports[j].Via {
+				return ports[i].Via < ports[j].Via
+			}
+			return ports[i].RemoteAddress < ports[j].RemoteAddress
+		})
+
+		return printOutput(ports, func() string {
+			return prettyPorts(ports)
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(portsCmd)
+}
@@ -0,0 +1,19 @@
+/*
+Copyright © 2023 Yong
+
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// profileCmd represents the profile command
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage the connection profiles in the config file",
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+}
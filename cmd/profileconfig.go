@@ -0,0 +1,349 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/ssh-proxy/server"
+	"github.com/superwhys/sshtunnel"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// hostYAML and profileYAML keep the keys of the profiles as documented in README,
+// yaml.v3 would lowercase them by default
+type hostYAML struct {
+	HostName     string `yaml:"HostName"`
+	User         string `yaml:"User,omitempty"`
+	IdentityFile string `yaml:"IdentityFile,omitempty"`
+}
+
+type profileYAML struct {
+	EnvName   string                 `yaml:"EnvName"`
+	Extends   string                 `yaml:"Extends,omitempty"`
+	Protected bool                   `yaml:"Protected,omitempty"`
+	Hosts     []hostYAML             `yaml:"Hosts,omitempty"`
+	Services  map[string]interface{} `yaml:"Services,omitempty"`
+}
+
+func (cp *ConnectionProfile) MarshalYAML() (interface{}, error) {
+	p := profileYAML{EnvName: cp.EnvName, Extends: cp.Extends, Protected: cp.Protected, Services: cp.Services}
+	for _, h := range cp.Hosts {
+		p.Hosts = append(p.Hosts, hostYAML{HostName: h.HostName, User: h.User, IdentityFile: h.IdentityFile})
+	}
+
+	// the keys of the services loaded by viper are lowercased, write them in canonical form
+	if aliases, err := cp.ServiceAliases(); err == nil && len(aliases) > 0 {
+		p.Services = make(map[string]interface{}, len(aliases))
+		for name, alias := range aliases {
+			if alias.LocalPort == 0 && len(alias.Labels) == 0 {
+				p.Services[name] = alias.Address
+			} else {
+				p.Services[name] = alias
+			}
+		}
+	}
+	return p, nil
+}
+
+// UnmarshalYAML matches the keys case-insensitively like the config loaded by viper
+func (cp *ConnectionProfile) UnmarshalYAML(value *yaml.Node) error {
+	var raw map[string]interface{}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	return mapstructure.Decode(raw, cp)
+}
+
+// expandHome expands the leading ~ of a path, which is not done by ssh libraries
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+// parseHostSpec parses a host in the form of [user@]host[:port]
+func parseHostSpec(spec string) (*sshtunnel.SshConfig, error) {
+	host := &sshtunnel.SshConfig{HostName: spec}
+	if user, hostName, ok := strings.Cut(spec, "@"); ok {
+		host.User = user
+		host.HostName = hostName
+	}
+	if host.HostName == "" {
+		return nil, fmt.Errorf("invalid host %q, it should be [user@]host[:port]", spec)
+	}
+	return host, nil
+}
+
+func validateHostName(hostName string) error {
+	host, port, err := net.SplitHostPort(hostName)
+	if err != nil {
+		// the port is optional, including for an ipv6 address
+		if !strings.Contains(hostName, ":") || net.ParseIP(strings.Trim(hostName, "[]")) != nil {
+			return nil
+		}
+		return errors.Wrapf(err, "invalid host %s", hostName)
+	}
+	if host == "" {
+		return fmt.Errorf("invalid host %s: empty host", hostName)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("invalid host %s: invalid port %s", hostName, port)
+	}
+	return nil
+}
+
+func validateIdentityFile(path string) error {
+	b, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return errors.Wrap(err, "read identity file")
+	}
+	if _, err := ssh.ParsePrivateKey(b); err != nil {
+		return errors.Wrapf(err, "parse identity file %s", path)
+	}
+	return nil
+}
+
+// Validate checks the hosts and identity files of the profile,
+// the identity file of the defaults is used for the hosts without one
+func (cp *ConnectionProfile) Validate(d *ProfileDefaults) error {
+	if cp.EnvName == "" {
+		return errors.New("profile env name is empty")
+	}
+	if len(cp.Hosts) == 0 && cp.Extends == "" {
+		return fmt.Errorf("profile %s has no hosts", cp.EnvName)
+	}
+
+	for i, h := range cp.Hosts {
+		if h.HostName == "" {
+			return fmt.Errorf("profile %s host %d: empty host name", cp.EnvName, i+1)
+		}
+		if err := validateHostName(h.HostName); err != nil {
+			return errors.Wrapf(err, "profile %s host %d", cp.EnvName, i+1)
+		}
+
+		file := h.IdentityFile
+		if file == "" {
+			file = d.IdentityFile
+		}
+		if err := validateIdentityFile(file); err != nil {
+			return errors.Wrapf(err, "profile %s host %d", cp.EnvName, i+1)
+		}
+	}
+
+	_, err := cp.ServiceAliases()
+	return err
+}
+
+// configFile edits the profiles of the config file through yaml nodes,
+// so the comments and the other keys of the file are kept
+type configFile struct {
+	path string
+	doc  *yaml.Node
+}
+
+// configFilePath returns the config file read by flags.Parse
+func configFilePath() string {
+	if path := flags.Viper().ConfigFileUsed(); path != "" {
+		return path
+	}
+	return defaultConfigFile
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	// keep the config file a symlink, e.g. managed in a dotfiles repo
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	cf := &configFile{path: path, doc: &yaml.Node{Kind: yaml.DocumentNode}}
+
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "read config file")
+	}
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err := yaml.Unmarshal(b, cf.doc); err != nil {
+			return nil, errors.Wrap(err, "parse config file")
+		}
+	}
+
+	if len(cf.doc.Content) == 0 {
+		cf.doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+	if cf.doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %s is not a yaml mapping", path)
+	}
+	return cf, nil
+}
+
+// mappingValue returns the value node of the key in the mapping node,
+// keys are matched case-insensitively like viper
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if strings.EqualFold(mapping.Content[i].Value, key) {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// profilesNode returns the sequence node of the profiles, which is created if not exists
+func (cf *configFile) profilesNode() *yaml.Node {
+	root := cf.doc.Content[0]
+	if profiles := mappingValue(root, "profiles"); profiles != nil {
+		if profiles.Kind != yaml.SequenceNode {
+			// e.g. an empty "profiles:"
+			profiles.Kind = yaml.SequenceNode
+			profiles.Tag = "!!seq"
+			profiles.Value = ""
+		}
+		return profiles
+	}
+
+	profiles := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "profiles"}, profiles)
+	return profiles
+}
+
+func (cf *configFile) indexProfile(envName string) int {
+	for i, item := range cf.profilesNode().Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		if name := mappingValue(item, "EnvName"); name != nil && name.Value == envName {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetProfile adds the profile or replaces the one of the same env,
+// the comments of the replaced profile are kept
+func (cf *configFile) SetProfile(profile *ConnectionProfile) (replaced bool, err error) {
+	node := &yaml.Node{}
+	if err := node.Encode(profile); err != nil {
+		return false, errors.Wrap(err, "encode profile")
+	}
+
+	profiles := cf.profilesNode()
+	i := cf.indexProfile(profile.EnvName)
+	if i < 0 {
+		profiles.Content = append(profiles.Content, node)
+		return false, nil
+	}
+
+	mergeComments(node, profiles.Content[i])
+	profiles.Content[i] = node
+	return true, nil
+}
+
+// mergeComments copies the comments of old to node, and orders the keys of node as in old,
+// the keys are matched case-insensitively and the hosts by their HostName
+func mergeComments(node, old *yaml.Node) {
+	node.HeadComment, node.LineComment, node.FootComment = old.HeadComment, old.LineComment, old.FootComment
+	if node.Kind != old.Kind {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		keyIndex := func(key string) int {
+			for j := 0; j+1 < len(old.Content); j += 2 {
+				if strings.EqualFold(old.Content[j].Value, key) {
+					return j
+				}
+			}
+			return -1
+		}
+		pairs := make([][2]*yaml.Node, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if j := keyIndex(key.Value); j >= 0 {
+				mergeComments(key, old.Content[j])
+				mergeComments(value, old.Content[j+1])
+			}
+			pairs = append(pairs, [2]*yaml.Node{key, value})
+		}
+		// the new keys go after the ones of old
+		sort.SliceStable(pairs, func(a, b int) bool {
+			ia, ib := keyIndex(pairs[a][0].Value), keyIndex(pairs[b][0].Value)
+			if ia < 0 || ib < 0 {
+				return ia >= 0 && ib < 0
+			}
+			return ia < ib
+		})
+		node.Content = node.Content[:0]
+		for _, pair := range pairs {
+			node.Content = append(node.Content, pair[0], pair[1])
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if j := matchSequenceItem(old, item, i); j >= 0 {
+				mergeComments(item, old.Content[j])
+			}
+		}
+	}
+}
+
+// matchSequenceItem returns the index of the item of seq with the same HostName,
+// or the same index for the items without it
+func matchSequenceItem(seq, item *yaml.Node, index int) int {
+	if name := mappingValue(item, "HostName"); item.Kind == yaml.MappingNode && name != nil {
+		for j, other := range seq.Content {
+			if other.Kind != yaml.MappingNode {
+				continue
+			}
+			if otherName := mappingValue(other, "HostName"); otherName != nil && otherName.Value == name.Value {
+				return j
+			}
+		}
+		return -1
+	}
+	if index < len(seq.Content) {
+		return index
+	}
+	return -1
+}
+
+func (cf *configFile) RemoveProfile(envName string) bool {
+	i := cf.indexProfile(envName)
+	if i < 0 {
+		return false
+	}
+
+	profiles := cf.profilesNode()
+	profiles.Content = append(profiles.Content[:i], profiles.Content[i+1:]...)
+	return true
+}
+
+// Save writes the config file back through a temp file, the file mode is kept
+func (cf *configFile) Save() error {
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cf.doc); err != nil {
+		return errors.Wrap(err, "encode config file")
+	}
+	if err := encoder.Close(); err != nil {
+		return errors.Wrap(err, "encode config file")
+	}
+
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(cf.path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return server.WriteFileAtomic(cf.path, buf.Bytes(), perm)
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/superwhys/sshtunnel"
+)
+
+const testConfig = `# ssh-proxy config
+port: 8080
+profiles:
+  # the bastion of dev
+  - Hosts:
+      # jump through the bastion
+      - HostName: bastion:22 # public
+        User: ops
+      - HostName: 10.0.0.5
+    EnvName: dev # keys in my order
+  - EnvName: qa
+    Hosts:
+      - HostName: 10.0.0.6
+`
+
+func TestConfigFile_SetProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  *ConnectionProfile
+		replaced bool
+		want     string
+	}{
+		{
+			name: "replace",
+			profile: &ConnectionProfile{EnvName: "dev", Hosts: []*sshtunnel.SshConfig{
+				{HostName: "10.0.0.9"},
+				{HostName: "bastion:22", User: "admin"},
+			}},
+			replaced: true,
+			want: `# ssh-proxy config
+port: 8080
+profiles:
+  # the bastion of dev
+  - Hosts:
+      - HostName: 10.0.0.9
+      # jump through the bastion
+      - HostName: bastion:22 # public
+        User: admin
+    EnvName: dev # keys in my order
+  - EnvName: qa
+    Hosts:
+      - HostName: 10.0.0.6
+`,
+		},
+		{
+			name:     "add",
+			profile:  &ConnectionProfile{EnvName: "prod", Hosts: []*sshtunnel.SshConfig{{HostName: "10.0.1.5"}}},
+			replaced: false,
+			want: testConfig + `  - EnvName: prod
+    Hosts:
+      - HostName: 10.0.1.5
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".ssh-proxy.yaml")
+			if err := os.WriteFile(path, []byte(testConfig), 0640); err != nil {
+				t.Fatal(err)
+			}
+			cf, err := loadConfigFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replaced, err := cf.SetProfile(tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replaced != tt.replaced {
+				t.Errorf("SetProfile() replaced = %v, want %v", replaced, tt.replaced)
+			}
+			if err := cf.Save(); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("saved config =\n%s\nwant\n%s", b, tt.want)
+			}
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+				t.Errorf("saved config mode = %v, %v, want 0640", info.Mode().Perm(), err)
+			}
+		})
+	}
+}
+
+func TestConfigFile_RemoveProfile(t *testing.T) {
+	tests := []struct {
+		envName string
+		removed bool
+		want    string
+	}{
+		{"qa", true, `# ssh-proxy config
+port: 8080
+profiles:
+  # the bastion of dev
+  - Hosts:
+      # jump through the bastion
+      - HostName: bastion:22 # public
+        User: ops
+      - HostName: 10.0.0.5
+    EnvName: dev # keys in my order
+`},
+		{"prod", false, testConfig},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), ".ssh-proxy.yaml")
+		if err := os.WriteFile(path, []byte(testConfig), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cf, err := loadConfigFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed := cf.RemoveProfile(tt.envName); removed != tt.removed {
+			t.Errorf("RemoveProfile(%q) = %v, want %v", tt.envName, removed, tt.removed)
+		}
+		if err := cf.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := os.ReadFile(path); err != nil || string(b) != tt.want {
+			t.Errorf("RemoveProfile(%q) saved config =\n%s\nwant\n%s", tt.envName, b, tt.want)
+		}
+	}
+}
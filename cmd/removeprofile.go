@@ -0,0 +1,62 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// removeprofileCmd represents the removeprofile command
+var removeprofileCmd = &cobra.Command{
+	Use:               "rm [--force] [env]",
+	Aliases:           []string{"remove"},
+	Short:             "Remove a connection profile",
+	ValidArgsFunction: completeEnvArg,
+	Args:              cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force := flags.Bool("force", false, "")
+		flags.Parse()
+
+		allProfiles, err := getAllProfiles()
+		if err != nil {
+			return err
+		}
+		var children []string
+		for _, p := range allProfiles {
+			if p.Extends == args[0] {
+				children = append(children, p.EnvName)
+			}
+		}
+		if len(children) > 0 {
+			if !force() {
+				return fmt.Errorf("profile %s is extended by %s, remove them or change their Extends first, or use --force", args[0], strings.Join(children, ", "))
+			}
+			lg.Warnf("Profiles %s extend %s, they can not be resolved until their Extends is changed", strings.Join(children, ", "), args[0])
+		}
+
+		cf, err := loadConfigFile(configFilePath())
+		if err != nil {
+			return err
+		}
+		if !cf.RemoveProfile(args[0]) {
+			return fmt.Errorf("profile %s not exists in %s", args[0], cf.path)
+		}
+		if err := cf.Save(); err != nil {
+			return err
+		}
+
+		lg.Infof("Profile %s removed from %s", args[0], cf.path)
+		return nil
+	},
+}
+
+func init() {
+	profileCmd.AddCommand(removeprofileCmd)
+	removeprofileCmd.Flags().Bool("force", false, "Remove the profile even if other profiles extend it")
+}
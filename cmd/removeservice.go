@@ -0,0 +1,40 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// removeserviceCmd represents the removeservice command
+var removeserviceCmd = &cobra.Command{
+	Use:               "remove [mesh] [service1] [service2] ...",
+	Aliases:           []string{"rm"},
+	Short:             "Remove services from existing mesh by name or remote address",
+	ValidArgsFunction: completeMeshServices,
+	Args:              cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+		meshName := args[0]
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+		for _, service := range args[1:] {
+			if err := serviceMesh.RemoveServiceFromMesh(meshName, service); err != nil {
+				return err
+			}
+			lg.Infof("Service %s removed from mesh %s", service, meshName)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(removeserviceCmd)
+}
@@ -0,0 +1,44 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// renamemeshCmd represents the renamemesh command
+var renamemeshCmd = &cobra.Command{
+	Use:               "rename [mesh] [newName] | [mesh] [service] [newName]",
+	Short:             "Rename a mesh, or a service in the mesh if service provided",
+	ValidArgsFunction: completeMeshArg,
+	Args:              cobra.RangeArgs(2, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 3 {
+			if err := serviceMesh.RenameService(args[0], args[1], args[2]); err != nil {
+				return err
+			}
+			lg.Infof("Service %s in mesh %s renamed to %s", args[1], args[0], args[2])
+			return nil
+		}
+
+		if err := serviceMesh.RenameMesh(args[0], args[1]); err != nil {
+			return err
+		}
+		lg.Infof("Mesh %s renamed to %s", args[0], args[1])
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(renamemeshCmd)
+}
@@ -4,37 +4,250 @@ Copyright © 2023 Yong
 package cmd
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/superwhys/goutils/flags"
 	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
 	"github.com/superwhys/sshtunnel"
 )
 
 var (
 	env            = flags.String("env", "", "Environment name for looking up connection profile")
 	profiles       = flags.Struct("profiles", []*ConnectionProfile{}, "Connection profiles")
+	confirm        = flags.Bool("confirm", false, "Confirm connecting to the protected envs")
+	defaults       = flags.Struct("defaults", &ProfileDefaults{}, "Defaults of the hosts in the connection profiles")
 	privateKeyPath = flags.String("privateKey", os.Getenv("HOME")+"/.ssh/id_rsa", "private key")
 	port           = flags.Int("port", 0, "Port for serivce")
+	meshStore      = flags.String("meshStore", server.MeshStoreFile, "Mesh store backend, file or bolt")
+	meshStorePath  = flags.String("meshStorePath", "", "Path of the mesh store, use the default path of the backend if empty")
+	envFormat      = flags.String("envFormat", server.DefaultEnvVarFormat, "Format of the env var names derived from the service names")
+	dotenv         = flags.String("dotenv", "", "Dotenv file updated with the addresses of the connected services")
+
+	defaultConfigFile = os.Getenv("HOME") + "/.ssh-proxy.yaml"
 
 	debug bool
 )
 
 type ConnectionProfile struct {
 	EnvName string
+	// Extends is the env of the parent profile, whose hosts are prepended to the hosts
+	Extends string
 	Hosts   []*sshtunnel.SshConfig
+	// Protected envs, e.g. production, are only connected with --confirm
+	Protected bool
+	// Services are the named services of the env, the value is either
+	// an address or a ProfileService, see ServiceAliases
+	Services map[string]interface{}
+}
+
+// ProfileService is a named service of the profile
+type ProfileService struct {
+	Address string `yaml:"Address"`
+	// LocalPort is the preferred local port of the service
+	LocalPort int               `yaml:"LocalPort,omitempty"`
+	Labels    map[string]string `yaml:"Labels,omitempty"`
+}
+
+// ServiceAliases returns the named services of the profile,
+// which are declared as "redis: 10.0.0.5:6379" or with the fields of ProfileService.
+// The names are lowercased since viper lowercases the keys of the config,
+// look them up with lookupAlias
+func (cp *ConnectionProfile) ServiceAliases() (map[string]*ProfileService, error) {
+	aliases := make(map[string]*ProfileService, len(cp.Services))
+	for name, value := range cp.Services {
+		service := &ProfileService{}
+		switch v := value.(type) {
+		case string:
+			service.Address = v
+		default:
+			if err := mapstructure.Decode(v, service); err != nil {
+				return nil, errors.Wrapf(err, "profile %s service %s", cp.EnvName, name)
+			}
+		}
+
+		if _, _, err := net.SplitHostPort(service.Address); err != nil {
+			return nil, errors.Wrapf(err, "profile %s service %s", cp.EnvName, name)
+		}
+		key := strings.ToLower(name)
+		if _, exists := aliases[key]; exists {
+			return nil, fmt.Errorf("profile %s has services only differing in case: %s", cp.EnvName, name)
+		}
+		aliases[key] = service
+	}
+	return aliases, nil
+}
+
+// lookupAlias finds the named service case-insensitively,
+// it returns the name in the form of ServiceAliases
+func lookupAlias(aliases map[string]*ProfileService, name string) (string, *ProfileService, bool) {
+	key := strings.ToLower(name)
+	alias, ok := aliases[key]
+	return key, alias, ok
 }
 
-func (cp *ConnectionProfile) PopulateDefault(identityFile string) {
+// ProfileDefaults are applied to the hosts of all profiles without these set
+type ProfileDefaults struct {
+	User         string
+	IdentityFile string
+	Port         int
+	// Keepalive is the interval of the keepalive requests sent to the ssh hosts, e.g. 30s
+	Keepalive time.Duration
+}
+
+// getProfileDefaults returns the defaults block of the config,
+// an explicit --privateKey overrides the identity file of the defaults
+func getProfileDefaults() (*ProfileDefaults, error) {
+	d := &ProfileDefaults{}
+	if err := defaults(d); err != nil {
+		return nil, err
+	}
+	if d.Keepalive < 0 || (d.Keepalive > 0 && d.Keepalive < time.Second) {
+		return nil, errors.Errorf("defaults.Keepalive %v is too short, set it with a unit like 30s", d.Keepalive)
+	}
+
+	if flag := pflag.Lookup("privateKey"); d.IdentityFile == "" || (flag != nil && flag.Changed) {
+		d.IdentityFile = privateKeyPath()
+	}
+	if d.User == "" {
+		d.User = "root"
+	}
+	return d, nil
+}
+
+func (cp *ConnectionProfile) PopulateDefault(d *ProfileDefaults) {
 	for _, h := range cp.Hosts {
 		if h.IdentityFile == "" {
-			h.IdentityFile = identityFile
+			h.IdentityFile = d.IdentityFile
 		}
+		h.IdentityFile = expandHome(h.IdentityFile)
 		if h.User == "" {
-			h.User = "root"
+			h.User = d.User
+		}
+		if _, _, err := net.SplitHostPort(h.HostName); err != nil {
+			if d.Port != 0 {
+				h.HostName = net.JoinHostPort(strings.Trim(h.HostName, "[]"), strconv.Itoa(d.Port))
+			} else if strings.Contains(h.HostName, ":") {
+				// sshtunnel only adds the default port to the hosts without ":", which misses an ipv6 address
+				h.HostName = net.JoinHostPort(strings.Trim(h.HostName, "[]"), "22")
+			}
+		}
+	}
+}
+
+func getAllProfiles() ([]*ConnectionProfile, error) {
+	var allProfiles []*ConnectionProfile
+	if err := profiles(&allProfiles); err != nil {
+		return nil, err
+	}
+	return allProfiles, nil
+}
+
+// resolveProfile returns a copy of the profile with the hosts of its parents prepended,
+// path is the envs being resolved to detect cycles
+func resolveProfile(allProfiles []*ConnectionProfile, envName string, path []string) (*ConnectionProfile, error) {
+	var profile *ConnectionProfile
+	var envNames []string
+	for _, p := range allProfiles {
+		if p.EnvName == envName {
+			profile = p
+			break
+		}
+		envNames = append(envNames, p.EnvName)
+	}
+	if profile == nil {
+		return nil, fmt.Errorf("No connection profile found. env=%s, available envs: %v, see ssh-proxy profile ls", envName, strings.Join(envNames, ", "))
+	}
+
+	resolved := &ConnectionProfile{EnvName: profile.EnvName, Protected: profile.Protected}
+	if profile.Extends != "" {
+		path = append(path, envName)
+		for _, p := range path {
+			if p == profile.Extends {
+				return nil, fmt.Errorf("profile extends cycle: %v", strings.Join(append(path, profile.Extends), " -> "))
+			}
+		}
+
+		parent, err := resolveProfile(allProfiles, profile.Extends, path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "profile %s extends %s", envName, profile.Extends)
 		}
+		resolved.Hosts = parent.Hosts
+		resolved.Services = parent.Services
+		// the envs behind a protected bastion are protected as well
+		resolved.Protected = resolved.Protected || parent.Protected
+	}
+	if len(profile.Services) > 0 {
+		services := make(map[string]interface{}, len(resolved.Services)+len(profile.Services))
+		for name, service := range resolved.Services {
+			services[name] = service
+		}
+		for name, service := range profile.Services {
+			services[name] = service
+		}
+		resolved.Services = services
+	}
+	for _, h := range profile.Hosts {
+		host := *h
+		resolved.Hosts = append(resolved.Hosts, &host)
 	}
+	return resolved, nil
+}
+
+// getProfile returns the profile of the env with the hosts of its parents
+func getProfile(envName string) (*ConnectionProfile, error) {
+	allProfiles, err := getAllProfiles()
+	if err != nil {
+		return nil, err
+	}
+	return resolveProfile(allProfiles, envName, nil)
+}
+
+// currentEnv returns the env of --env, or the one selected by ssh-proxy use
+func currentEnv() string {
+	if env() != "" {
+		return env()
+	}
+
+	current, err := server.NewState(server.StateDir()).CurrentEnv()
+	if err != nil {
+		lg.Warnf("Failed to get current env: %v", err)
+		return ""
+	}
+	return current
+}
+
+// getDialProfile returns the profile of the env populated with the defaults, which is ready to dial
+func getDialProfile(envName string) (*ConnectionProfile, error) {
+	profile, err := getProfile(envName)
+	if err != nil {
+		return nil, err
+	}
+	d, err := getProfileDefaults()
+	if err != nil {
+		return nil, err
+	}
+	profile.PopulateDefault(d)
+	return profile, nil
+}
+
+// newServiceMesh creates the ServiceMesh with the store selected by config
+func newServiceMesh() (*server.ServiceMesh, error) {
+	store, err := server.NewMeshStore(meshStore(), meshStorePath())
+	if err != nil {
+		return nil, err
+	}
+
+	return server.NewServiceMesh(store), nil
 }
 
 var rootCmd = &cobra.Command{
@@ -44,10 +257,16 @@ var rootCmd = &cobra.Command{
 		if debug {
 			lg.EnableDebug()
 		}
+		migrateState(cmd)
 	},
 }
 
 func Execute() {
+	if err := redirectLogs(); err != nil {
+		lg.Warnf("Failed to redirect logs to stderr: %v", err)
+	}
+	rootCmd.SetOut(stdout)
+
 	err := rootCmd.Execute()
 	if err != nil {
 		os.Exit(1)
@@ -55,6 +274,9 @@ func Execute() {
 }
 
 func init() {
-	flags.OverrideDefaultConfigFile(os.Getenv("HOME") + "/.ssh-proxy.yaml")
+	flags.OverrideDefaultConfigFile(defaultConfigFile)
+	// flags.Parse parses the command line again with the global flag set,
+	// flags only defined on a cobra command are already validated by cobra
+	pflag.CommandLine.ParseErrorsWhitelist.UnknownFlags = true
 	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "debug mode")
 }
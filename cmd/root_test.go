@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/sshtunnel"
+)
+
+func TestPopulateDefault(t *testing.T) {
+	d := &ProfileDefaults{User: "ops", IdentityFile: "/keys/id_ed25519", Port: 2222}
+	tests := []struct {
+		name     string
+		defaults *ProfileDefaults
+		host     sshtunnel.SshConfig
+		want     sshtunnel.SshConfig
+	}{
+		{"defaults", d, sshtunnel.SshConfig{HostName: "bastion"}, sshtunnel.SshConfig{HostName: "bastion:2222", User: "ops", IdentityFile: "/keys/id_ed25519"}},
+		{"host overrides", d, sshtunnel.SshConfig{HostName: "bastion:22", User: "admin", IdentityFile: "/keys/id_rsa"}, sshtunnel.SshConfig{HostName: "bastion:22", User: "admin", IdentityFile: "/keys/id_rsa"}},
+		{"ipv6 with default port", d, sshtunnel.SshConfig{HostName: "::1"}, sshtunnel.SshConfig{HostName: "[::1]:2222", User: "ops", IdentityFile: "/keys/id_ed25519"}},
+		{"ipv6 without default port", &ProfileDefaults{User: "root"}, sshtunnel.SshConfig{HostName: "[::1]"}, sshtunnel.SshConfig{HostName: "[::1]:22", User: "root"}},
+		{"no default port", &ProfileDefaults{User: "root"}, sshtunnel.SshConfig{HostName: "bastion"}, sshtunnel.SshConfig{HostName: "bastion", User: "root"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := tt.host
+			profile := &ConnectionProfile{Hosts: []*sshtunnel.SshConfig{&host}}
+			profile.PopulateDefault(tt.defaults)
+			if !reflect.DeepEqual(host, tt.want) {
+				t.Errorf("PopulateDefault() = %+v, want %+v", host, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetProfileDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		want    *ProfileDefaults
+		wantErr bool
+	}{
+		{"empty", map[string]interface{}{}, &ProfileDefaults{User: "root", IdentityFile: privateKeyPath()}, false},
+		{
+			"set",
+			map[string]interface{}{"User": "ops", "IdentityFile": "/keys/id_ed25519", "Port": 2222, "Keepalive": "30s"},
+			&ProfileDefaults{User: "ops", IdentityFile: "/keys/id_ed25519", Port: 2222, Keepalive: 30 * time.Second},
+			false,
+		},
+		{"keepalive without unit", map[string]interface{}{"Keepalive": "30"}, nil, true},
+		{"keepalive too short", map[string]interface{}{"Keepalive": "10ms"}, nil, true},
+	}
+	t.Cleanup(func() { flags.Viper().Set("defaults", map[string]interface{}{}) })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags.Viper().Set("defaults", tt.config)
+			got, err := getProfileDefaults()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getProfileDefaults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getProfileDefaults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceAliases(t *testing.T) {
+	tests := []struct {
+		name     string
+		services map[string]interface{}
+		lookup   string
+		want     *ProfileService
+		wantErr  bool
+	}{
+		{"address", map[string]interface{}{"redis": "10.0.0.5:6379"}, "redis", &ProfileService{Address: "10.0.0.5:6379"}, false},
+		{"lookup in other case", map[string]interface{}{"redis": "10.0.0.5:6379"}, "REDIS", &ProfileService{Address: "10.0.0.5:6379"}, false},
+		{"declared in other case", map[string]interface{}{"MySQL": "10.0.0.6:3306"}, "mysql", &ProfileService{Address: "10.0.0.6:3306"}, false},
+		{
+			"fields",
+			map[string]interface{}{"mysql": map[string]interface{}{"Address": "10.0.0.6:3306", "LocalPort": 13306, "Labels": map[string]string{"tier": "db"}}},
+			"Mysql",
+			&ProfileService{Address: "10.0.0.6:3306", LocalPort: 13306, Labels: map[string]string{"tier": "db"}},
+			false,
+		},
+		{"not found", map[string]interface{}{"redis": "10.0.0.5:6379"}, "mysql", nil, false},
+		{"collision", map[string]interface{}{"redis": "10.0.0.5:6379", "Redis": "10.0.0.7:6379"}, "redis", nil, true},
+		{"invalid address", map[string]interface{}{"redis": "10.0.0.5"}, "redis", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aliases, err := (&ConnectionProfile{EnvName: "dev", Services: tt.services}).ServiceAliases()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ServiceAliases() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			name, got, ok := lookupAlias(aliases, tt.lookup)
+			if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookupAlias(%q) = %+v, %v, want %+v", tt.lookup, got, ok, tt.want)
+			}
+			if ok && name != strings.ToLower(tt.lookup) {
+				t.Errorf("lookupAlias(%q) name = %q, want the lowercased name", tt.lookup, name)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+)
+
+// setenvmeshCmd represents the setenvmesh command
+var setenvmeshCmd = &cobra.Command{
+	Use:               "set-env [mesh] [env]",
+	Short:             "Change the env of a mesh",
+	ValidArgsFunction: completeMeshArg,
+	Args:              cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+		meshName, envName := args[0], args[1]
+
+		if _, err := getProfile(envName); err != nil {
+			lg.Warnf("%v, the mesh can not be connected until the profile is added", err)
+		}
+
+		serviceMesh, err := newServiceMesh()
+		if err != nil {
+			return err
+		}
+		if err := serviceMesh.SetMeshEnv(meshName, envName); err != nil {
+			return err
+		}
+		lg.Infof("Mesh %s env changed to %s", meshName, envName)
+
+		return nil
+	},
+}
+
+func init() {
+	meshCmd.AddCommand(setenvmeshCmd)
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"gopkg.in/yaml.v3"
+)
+
+// showprofileCmd represents the showprofile command
+var showprofileCmd = &cobra.Command{
+	Use:               "show [env]",
+	Short:             "Show a connection profile",
+	ValidArgsFunction: completeEnvArg,
+	Args:              cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+
+		envName := currentEnv()
+		if len(args) > 0 {
+			envName = args[0]
+		}
+		profile, err := getProfile(envName)
+		if err != nil {
+			return err
+		}
+		b, err := yaml.Marshal(profile)
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(b)
+		return err
+	},
+}
+
+func init() {
+	profileCmd.AddCommand(showprofileCmd)
+}
@@ -0,0 +1,19 @@
+/*
+Copyright © 2023 Yong
+
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// stateCmd represents the state command
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Manage the state dir of meshes and port cache",
+}
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+}
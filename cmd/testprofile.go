@@ -0,0 +1,51 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+// testprofileCmd represents the testprofile command
+var testprofileCmd = &cobra.Command{
+	Use:               "test [--timeout 5s] [env]",
+	Short:             "Dial the hosts of a connection profile hop by hop",
+	ValidArgsFunction: completeEnvArg,
+	Args:              cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout := flags.Duration("timeout", 5*time.Second, "")
+		flags.Parse()
+
+		envName := currentEnv()
+		if len(args) > 0 {
+			envName = args[0]
+		}
+		profile, err := getDialProfile(envName)
+		if err != nil {
+			return err
+		}
+
+		results := server.CheckEnv(profile.EnvName, profile.Hosts, nil, timeout())
+		lg.Infof("Profile %s test results: \n%s", profile.EnvName, prettyCheckResults(results))
+
+		for _, r := range results {
+			if !r.OK() {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("profile %s failed at %s %s: %v", profile.EnvName, r.Name, r.Address, r.Err)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	profileCmd.AddCommand(testprofileCmd)
+	testprofileCmd.Flags().Duration("timeout", 5*time.Second, "Timeout of each ssh hop")
+}
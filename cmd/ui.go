@@ -0,0 +1,726 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	uiRefreshInterval = time.Second
+	uiRequestTimeout  = time.Second
+	// connecting may dial the ssh tunnels of the envs
+	uiConnectTimeout = time.Minute
+)
+
+// uiItem is a service which can be picked in the ui,
+// from the aliases of a profile or from a mesh
+type uiItem struct {
+	// source is the env or the mesh of the service
+	source  string
+	mesh    bool
+	name    string
+	address string
+	labels  map[string]string
+}
+
+func (it uiItem) text() string {
+	return it.source + " " + it.name + " " + it.address
+}
+
+// fuzzyScore matches pattern as a subsequence of text case insensitively,
+// consecutive runes and the runes at the start of a word score higher
+func fuzzyScore(pattern, text string) (int, bool) {
+	pr := []rune(strings.ToLower(pattern))
+	if len(pr) == 0 {
+		return 0, true
+	}
+
+	score, matched, last := 0, 0, -2
+	tr := []rune(strings.ToLower(text))
+	for i, r := range tr {
+		if matched == len(pr) {
+			break
+		}
+		if r != pr[matched] {
+			continue
+		}
+		score++
+		if i == last+1 {
+			score += 2
+		}
+		if i == 0 || !unicode.IsLetter(tr[i-1]) && !unicode.IsDigit(tr[i-1]) {
+			score++
+		}
+		last = i
+		matched++
+	}
+	return score, matched == len(pr)
+}
+
+// uiItems lists the services of the profiles and the meshes to pick
+func uiItems(meshClient sshproxypb.MeshServiceClient) ([]uiItem, error) {
+	var items []uiItem
+
+	allProfiles, err := getAllProfiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range allProfiles {
+		// a broken profile is skipped as in profile ls, the others can still be picked
+		profile, err := resolveProfile(allProfiles, p.EnvName, nil)
+		if err != nil {
+			lg.Warnf("skip profile %s: %v", p.EnvName, err)
+			continue
+		}
+		aliases, err := profile.ServiceAliases()
+		if err != nil {
+			lg.Warnf("skip profile %s: %v", p.EnvName, err)
+			continue
+		}
+		for name, alias := range aliases {
+			items = append(items, uiItem{
+				source:  profile.EnvName,
+				name:    name,
+				address: alias.Address,
+				labels:  alias.Labels,
+			})
+		}
+	}
+
+	// the services of the included meshes are connected with the mesh too
+	ctx, cancel := context.WithTimeout(context.Background(), uiRequestTimeout)
+	defer cancel()
+	resp, err := meshClient.ListMeshes(ctx, &sshproxypb.ListMeshesRequest{Expand: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "list meshes")
+	}
+	for _, m := range resp.GetMeshes() {
+		for _, service := range m.GetServices() {
+			items = append(items, uiItem{
+				source:  m.GetName(),
+				mesh:    true,
+				name:    service.GetServiceName(),
+				address: service.GetRemoteAddr(),
+				labels:  service.GetLabels(),
+			})
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].mesh != items[j].mesh {
+			return !items[i].mesh
+		}
+		if items[i].source != items[j].source {
+			return items[i].source < items[j].source
+		}
+		return items[i].name < items[j].name
+	})
+	return items, nil
+}
+
+// connectRequests groups the picked services by their source, the services of a
+// profile are connected as an unsaved mesh of the env
+func connectRequests(items []uiItem) []*sshproxypb.ConnectMeshRequest {
+	var reqs []*sshproxypb.ConnectMeshRequest
+	bySource := make(map[string]*sshproxypb.ConnectMeshRequest)
+	for _, it := range items {
+		key := fmt.Sprintf("%v/%s", it.mesh, it.source)
+		req, exists := bySource[key]
+		if !exists {
+			req = &sshproxypb.ConnectMeshRequest{}
+			if it.mesh {
+				req.Name = it.source
+			} else {
+				req.Mesh = &sshproxypb.Mesh{Name: "ui-" + it.source, Env: it.source}
+			}
+			bySource[key] = req
+			reqs = append(reqs, req)
+		}
+
+		if it.mesh {
+			req.Services = append(req.Services, it.name)
+		} else {
+			req.Mesh.Services = append(req.Mesh.Services, &sshproxypb.MeshServiceItem{
+				ServiceName: it.name,
+				RemoteAddr:  it.address,
+				Labels:      it.labels,
+			})
+		}
+	}
+	return reqs
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// copyToClipboard asks the terminal to copy s by the OSC 52 escape sequence,
+// which also works over ssh
+func copyToClipboard(s string) {
+	fmt.Fprintf(stdout, "\x1b]52;c;%s\x07", base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+type uiView int
+
+const (
+	pickView uiView = iota
+	nodesView
+)
+
+type (
+	uiTickMsg  time.Time
+	uiNodesMsg struct {
+		nodes []*sshproxypb.Node
+		err   error
+	}
+	uiDoneMsg struct {
+		status string
+		err    error
+		// confirm are the picked services of the protected envs,
+		// which are connected once confirmed
+		confirm []uiItem
+	}
+)
+
+type uiModel struct {
+	tunnel sshproxypb.ServiceTunnelClient
+	mesh   sshproxypb.MeshServiceClient
+	// target describes the ssh-proxy the ui talks to
+	target string
+
+	view   uiView
+	height int
+	status string
+
+	items    []uiItem
+	filter   string
+	matches  []int
+	selected map[int]bool
+	cursor   int
+
+	nodes      []*sshproxypb.Node
+	nodeCursor int
+
+	// confirming are the services of the protected envs waiting for y
+	confirming []uiItem
+}
+
+func newUIModel(tunnel sshproxypb.ServiceTunnelClient, mesh sshproxypb.MeshServiceClient, target string, items []uiItem) *uiModel {
+	m := &uiModel{
+		tunnel:   tunnel,
+		mesh:     mesh,
+		target:   target,
+		items:    items,
+		selected: make(map[int]bool),
+	}
+	m.applyFilter()
+	return m
+}
+
+func (m *uiModel) applyFilter() {
+	type match struct {
+		idx   int
+		score int
+	}
+	var matches []match
+	for idx, it := range m.items {
+		if score, ok := fuzzyScore(m.filter, it.text()); ok {
+			matches = append(matches, match{idx, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	m.matches = m.matches[:0]
+	for _, match := range matches {
+		m.matches = append(m.matches, match.idx)
+	}
+	m.cursor = 0
+}
+
+func uiTick() tea.Cmd {
+	return tea.Tick(uiRefreshInterval, func(t time.Time) tea.Msg {
+		return uiTickMsg(t)
+	})
+}
+
+func (m *uiModel) fetchNodes() tea.Msg {
+	ctx, cancel := context.WithTimeout(context.Background(), uiRequestTimeout)
+	defer cancel()
+	resp, err := m.tunnel.GetConnectNodes(ctx, &sshproxypb.GetConnectNodesRequest{})
+	if err != nil {
+		return uiNodesMsg{err: err}
+	}
+	nodes := resp.GetConnectedNodes()
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].GetServiceName() != nodes[j].GetServiceName() {
+			return nodes[i].GetServiceName() < nodes[j].GetServiceName()
+		}
+		return nodes[i].GetLocalAddress() < nodes[j].GetLocalAddress()
+	})
+	return uiNodesMsg{nodes: nodes}
+}
+
+// connect connects the picked services, the ones of the protected envs are only connected
+// with confirm, otherwise they are returned to be confirmed by the user
+func (m *uiModel) connect(items []uiItem, confirm bool) tea.Cmd {
+	return func() tea.Msg {
+		count := 0
+		var protected []uiItem
+		var reasons []string
+		for _, req := range connectRequests(items) {
+			source := req.GetName()
+			if req.GetMesh() != nil {
+				source = req.GetMesh().GetEnv()
+			}
+			req.Confirm = confirm
+			ctx, cancel := context.WithTimeout(context.Background(), uiConnectTimeout)
+			resp, err := m.mesh.ConnectMesh(ctx, req)
+			cancel()
+			if status.Code(err) == codes.FailedPrecondition {
+				for _, it := range items {
+					if it.mesh == (req.GetMesh() == nil) && it.source == source {
+						protected = append(protected, it)
+					}
+				}
+				reasons = append(reasons, status.Convert(err).Message())
+				continue
+			}
+			if err != nil {
+				return uiDoneMsg{err: errors.Wrapf(err, "connect %s", source)}
+			}
+			count += len(resp.GetConnectedNodes())
+		}
+
+		msg := uiDoneMsg{status: fmt.Sprintf("%d of %d services connected", count, len(items)), confirm: protected}
+		if len(protected) > 0 {
+			msg.status += fmt.Sprintf(", %s, press y to connect them", strings.Join(reasons, ", "))
+		}
+		return msg
+	}
+}
+
+func (m *uiModel) disconnect(node *sshproxypb.Node) error {
+	ctx, cancel := context.WithTimeout(context.Background(), uiRequestTimeout)
+	defer cancel()
+	_, err := m.tunnel.Disconnect(ctx, &sshproxypb.DisconnectRequest{
+		HostAddress:  node.GetHostAddress(),
+		ProxyAddress: node.GetRemoteAddress(),
+		LocalAddress: node.GetLocalAddress(),
+	})
+	return errors.Wrapf(err, "disconnect %s", node.GetServiceName())
+}
+
+// reconnect connects the node again on the same local address
+func (m *uiModel) reconnect(node *sshproxypb.Node) tea.Cmd {
+	return func() tea.Msg {
+		service := &sshproxypb.Service{
+			ServiceName:   node.GetServiceName(),
+			RemoteAddress: node.GetHostAddress(),
+			ProxyAddress:  node.GetRemoteAddress(),
+		}
+		if host, port, err := net.SplitHostPort(node.GetLocalAddress()); err == nil {
+			p, _ := strconv.Atoi(port)
+			service.LocalHost, service.LocalPort = host, int32(p)
+		}
+		if node.GetTag() != "" {
+			labels, err := server.ParseLabels(node.GetTag())
+			if err != nil {
+				return uiDoneMsg{err: err}
+			}
+			service.Labels = labels
+		}
+
+		if err := m.disconnect(node); err != nil {
+			return uiDoneMsg{err: err}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), uiConnectTimeout)
+		defer cancel()
+		resp, err := m.tunnel.Connect(ctx, &sshproxypb.ConnectRequest{Services: []*sshproxypb.Service{service}})
+		if err != nil {
+			return uiDoneMsg{err: errors.Wrapf(err, "reconnect %s", node.GetServiceName())}
+		}
+		if len(resp.GetConnectedNodes()) == 0 {
+			return uiDoneMsg{err: errors.Errorf("reconnect %s failed, see the logs", node.GetServiceName())}
+		}
+		return uiDoneMsg{status: fmt.Sprintf("%s reconnected", node.GetServiceName())}
+	}
+}
+
+func (m *uiModel) Init() tea.Cmd {
+	return tea.Batch(m.fetchNodes, uiTick())
+}
+
+func (m *uiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+	case uiTickMsg:
+		return m, tea.Batch(m.fetchNodes, uiTick())
+	case uiNodesMsg:
+		if msg.err != nil {
+			m.status = fmt.Sprintf("get nodes: %v", msg.err)
+			return m, nil
+		}
+		m.nodes = msg.nodes
+		if m.nodeCursor >= len(m.nodes) && m.nodeCursor > 0 {
+			m.nodeCursor = len(m.nodes) - 1
+		}
+	case uiDoneMsg:
+		m.status = msg.status
+		if msg.err != nil {
+			m.status = msg.err.Error()
+		}
+		m.confirming = msg.confirm
+		return m, m.fetchNodes
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+		if m.confirming != nil {
+			items := m.confirming
+			m.confirming = nil
+			if msg.String() != "y" {
+				m.status = "the services of the protected envs are not connected"
+				return m, nil
+			}
+			m.status = fmt.Sprintf("connecting %d services of the protected envs...", len(items))
+			return m, m.connect(items, true)
+		}
+		if m.view == pickView {
+			return m.updatePick(msg)
+		}
+		return m.updateNodes(msg)
+	}
+	return m, nil
+}
+
+func (m *uiModel) updatePick(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "esc":
+		if m.filter == "" {
+			return m, tea.Quit
+		}
+		m.filter = ""
+		m.applyFilter()
+	case "tab":
+		m.view = nodesView
+	case "up":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down":
+		if m.cursor < len(m.matches)-1 {
+			m.cursor++
+		}
+	case "backspace":
+		if r := []rune(m.filter); len(r) > 0 {
+			m.filter = string(r[:len(r)-1])
+			m.applyFilter()
+		}
+	case " ":
+		if len(m.matches) > 0 {
+			idx := m.matches[m.cursor]
+			m.selected[idx] = !m.selected[idx]
+			if m.cursor < len(m.matches)-1 {
+				m.cursor++
+			}
+		}
+	case "enter":
+		var items []uiItem
+		for idx, it := range m.items {
+			if m.selected[idx] {
+				items = append(items, it)
+			}
+		}
+		if len(items) == 0 && len(m.matches) > 0 {
+			items = append(items, m.items[m.matches[m.cursor]])
+		}
+		if len(items) == 0 {
+			return m, nil
+		}
+		m.selected = make(map[int]bool)
+		m.view = nodesView
+		m.status = fmt.Sprintf("connecting %d services...", len(items))
+		return m, m.connect(items, false)
+	default:
+		if msg.Type == tea.KeyRunes {
+			m.filter += string(msg.Runes)
+			m.applyFilter()
+		}
+	}
+	return m, nil
+}
+
+func (m *uiModel) updateNodes(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var node *sshproxypb.Node
+	if len(m.nodes) > 0 {
+		node = m.nodes[m.nodeCursor]
+	}
+
+	switch msg.String() {
+	case "q", "esc":
+		return m, tea.Quit
+	case "tab", "/":
+		m.view = pickView
+	case "up", "k":
+		if m.nodeCursor > 0 {
+			m.nodeCursor--
+		}
+	case "down", "j":
+		if m.nodeCursor < len(m.nodes)-1 {
+			m.nodeCursor++
+		}
+	case "d":
+		if node == nil {
+			return m, nil
+		}
+		return m, func() tea.Msg {
+			if err := m.disconnect(node); err != nil {
+				return uiDoneMsg{err: err}
+			}
+			return uiDoneMsg{status: fmt.Sprintf("%s disconnected", node.GetServiceName())}
+		}
+	case "r":
+		if node == nil {
+			return m, nil
+		}
+		m.status = fmt.Sprintf("reconnecting %s...", node.GetServiceName())
+		return m, m.reconnect(node)
+	case "c":
+		if node == nil {
+			return m, nil
+		}
+		addr := server.NodeLocalAddr(node)
+		copyToClipboard(addr)
+		m.status = fmt.Sprintf("copied %s", addr)
+	}
+	return m, nil
+}
+
+// visibleRange returns the rows around the cursor fitting the height of the terminal
+func (m *uiModel) visibleRange(total, cursor, reserved int) (int, int) {
+	rows := m.height - reserved
+	if m.height == 0 || rows <= 0 || total <= rows {
+		return 0, total
+	}
+	start := cursor - rows/2
+	if start > total-rows {
+		start = total - rows
+	}
+	if start < 0 {
+		start = 0
+	}
+	return start, start + rows
+}
+
+func (m *uiModel) View() string {
+	buffer := &bytes.Buffer{}
+	tabs := "[pick] nodes"
+	if m.view == nodesView {
+		tabs = "pick [nodes]"
+	}
+	fmt.Fprintf(buffer, "ssh-proxy ui · %s    %s\n\n", m.target, tabs)
+
+	if m.view == pickView {
+		m.viewPick(buffer)
+	} else {
+		m.viewNodes(buffer)
+	}
+
+	fmt.Fprintf(buffer, "\n%s\n", m.status)
+	return buffer.String()
+}
+
+func (m *uiModel) viewPick(buffer *bytes.Buffer) {
+	fmt.Fprintf(buffer, "filter> %s\n", m.filter)
+
+	w := tabwriter.NewWriter(buffer, 0, 0, 2, ' ', 0)
+	start, end := m.visibleRange(len(m.matches), m.cursor, 8)
+	for i := start; i < end; i++ {
+		idx := m.matches[i]
+		it := m.items[idx]
+		cursor, check, kind := " ", " ", "env"
+		if i == m.cursor {
+			cursor = ">"
+		}
+		if m.selected[idx] {
+			check = "x"
+		}
+		if it.mesh {
+			kind = "mesh"
+		}
+		fmt.Fprintf(w, "%s [%s]\t%s %s\t%s\t%s\n", cursor, check, kind, it.source, it.name, it.address)
+	}
+	w.Flush()
+	if len(m.matches) == 0 {
+		fmt.Fprintln(buffer, "  no services matched")
+	}
+
+	fmt.Fprintf(buffer, "\n%d selected · type to filter · ↑/↓ move · space select · enter connect · tab nodes · esc quit\n", len(m.selected))
+}
+
+func (m *uiModel) viewNodes(buffer *bytes.Buffer) {
+	w := tabwriter.NewWriter(buffer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  SERVICE\tHOST\tREMOTE\tLOCAL\tSTATE\tCONNS\tSENT\tRECEIVED")
+	start, end := m.visibleRange(len(m.nodes), m.nodeCursor, 8)
+	for i := start; i < end; i++ {
+		node := m.nodes[i]
+		cursor, state := " ", "ok"
+		if i == m.nodeCursor {
+			cursor = ">"
+		}
+		if node.GetLastError() != "" {
+			state = "error: " + node.GetLastError()
+		}
+		fmt.Fprintf(w, "%s %s\t%s\t%s\t%s\t%s\t%d/%d\t%s\t%s\n",
+			cursor,
+			node.GetServiceName(),
+			node.GetHostAddress(),
+			node.GetRemoteAddress(),
+			node.GetLocalAddress(),
+			state,
+			node.GetActiveConnections(),
+			node.GetTotalConnections(),
+			humanBytes(node.GetBytesSent()),
+			humanBytes(node.GetBytesReceived()),
+		)
+	}
+	w.Flush()
+	if len(m.nodes) == 0 {
+		fmt.Fprintln(buffer, "  no services connected, press tab to pick some")
+	}
+
+	fmt.Fprintln(buffer, "\n↑/↓ move · d disconnect · r reconnect · c copy address · tab pick · q quit")
+}
+
+// startUIServer serves the ServiceTunnel and MeshServer of a new session on a loopback port
+// for the ui, the returned stop closes the session with all its services
+func startUIServer() (*grpc.ClientConn, func(), error) {
+	serviceMesh, err := newServiceMesh()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "listen ui server")
+	}
+
+	st := server.NewServiceTunnel()
+	watchDotenv(st)
+	srv := grpc.NewServer()
+	sshproxypb.RegisterServiceTunnelServer(srv, st)
+	sshproxypb.RegisterMeshServiceServer(srv, server.NewMeshServer(serviceMesh, st, dialTunnel, isProtected))
+	go srv.Serve(l)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		srv.Stop()
+		st.Close()
+		return nil, nil, errors.Wrap(err, "dial ui server")
+	}
+	return conn, func() {
+		conn.Close()
+		srv.Stop()
+		st.Close()
+	}, nil
+}
+
+// uiCmd represents the ui command
+var uiCmd = &cobra.Command{
+	Use:   "ui [--port port]",
+	Short: "Pick services to connect and watch the connected ones in a terminal ui",
+	Long: `Pick the services of the profiles and the meshes with fuzzy search to connect,
+	and watch the state, local address, connections and traffic of the connected services, e.g.
+
+	ssh-proxy ui                 # a new session, which is closed on quit
+	ssh-proxy ui --port 8080     # attach to the ssh-proxy running with --port 8080
+
+	the logs are written to ui.log in the state dir while the ui is running
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags.Parse()
+
+		target := fmt.Sprintf("attached to 127.0.0.1:%d", port())
+		var conn *grpc.ClientConn
+		var err error
+		if port() != 0 {
+			conn, err = dialInstance()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+		} else {
+			var stop func()
+			conn, stop, err = startUIServer()
+			if err != nil {
+				return err
+			}
+			defer stop()
+			target = "new session, closed on quit"
+		}
+		tunnelClient := sshproxypb.NewServiceTunnelClient(conn)
+		meshClient := sshproxypb.NewMeshServiceClient(conn)
+
+		items, err := uiItems(meshClient)
+		if err != nil {
+			return err
+		}
+
+		logFile, err := server.OpenStateLog("ui.log")
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		lg.Infof("The logs are written to %s", logFile.Name())
+		stderr, err := redirectLogsTo(logFile)
+		if err != nil {
+			return errors.Wrap(err, "redirect logs")
+		}
+		// keep the error of the command on the terminal
+		cmd.Root().SetErr(stderr)
+
+		model := newUIModel(tunnelClient, meshClient, target, items)
+		_, err = tea.NewProgram(model, tea.WithAltScreen(), tea.WithOutput(stdout)).Run()
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(uiCmd)
+}
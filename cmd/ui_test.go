@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		pattern, text string
+		match         bool
+	}{
+		{"", "dev redis 10.0.0.5:6379", true},
+		{"rds", "dev redis 10.0.0.5:6379", true},
+		{"REDIS", "dev redis 10.0.0.5:6379", true},
+		{"devredis", "dev redis 10.0.0.5:6379", true},
+		{"sider", "dev redis 10.0.0.5:6379", false},
+		{"mysql", "dev redis 10.0.0.5:6379", false},
+	}
+	for _, tt := range tests {
+		if _, ok := fuzzyScore(tt.pattern, tt.text); ok != tt.match {
+			t.Errorf("fuzzyScore(%q, %q) matched = %v, want %v", tt.pattern, tt.text, ok, tt.match)
+		}
+	}
+
+	// consecutive runes and the starts of words rank higher
+	consecutive, _ := fuzzyScore("red", "dev redis")
+	scattered, _ := fuzzyScore("red", "dev rmxexd")
+	if consecutive <= scattered {
+		t.Errorf("fuzzyScore of consecutive runes = %d, want it higher than %d", consecutive, scattered)
+	}
+	wordStart, _ := fuzzyScore("r", "dev redis")
+	inWord, _ := fuzzyScore("r", "dev mirror")
+	if wordStart <= inWord {
+		t.Errorf("fuzzyScore of a word start = %d, want it higher than %d", wordStart, inWord)
+	}
+}
+
+func TestConnectRequests(t *testing.T) {
+	reqs := connectRequests([]uiItem{
+		{source: "dev", name: "redis", address: "10.0.0.5:6379", labels: map[string]string{"tier": "cache"}},
+		{source: "mesh-test", mesh: true, name: "auth"},
+		{source: "dev", name: "mysql", address: "10.0.0.6:3306"},
+		{source: "mesh-test", mesh: true, name: "web"},
+		// a mesh named as an env is another source
+		{source: "dev", mesh: true, name: "api"},
+	})
+	if len(reqs) != 3 {
+		t.Fatalf("connectRequests() = %d requests, want 3", len(reqs))
+	}
+
+	dev := reqs[0]
+	if dev.GetName() != "" || dev.GetMesh().GetEnv() != "dev" || len(dev.GetMesh().GetServices()) != 2 {
+		t.Errorf("request of env dev = %v, want an unsaved mesh of env dev with 2 services", dev)
+	}
+	if redis := dev.GetMesh().GetServices()[0]; redis.GetRemoteAddr() != "10.0.0.5:6379" || redis.GetLabels()["tier"] != "cache" {
+		t.Errorf("service redis = %v, want its address and labels", redis)
+	}
+
+	mesh := reqs[1]
+	if mesh.GetName() != "mesh-test" || mesh.GetMesh() != nil || len(mesh.GetServices()) != 2 {
+		t.Errorf("request of mesh mesh-test = %v, want the mesh with services auth and web", mesh)
+	}
+	if reqs[2].GetName() != "dev" || len(reqs[2].GetServices()) != 1 {
+		t.Errorf("request of mesh dev = %v, want the mesh with service api", reqs[2])
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+)
+
+// useCmd represents the use command
+var useCmd = &cobra.Command{
+	Use:               "use [--clear] [env]",
+	Short:             "Select the env used by the commands without --env",
+	ValidArgsFunction: completeEnvArg,
+	Args:              cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		clear := flags.Bool("clear", false, "")
+		flags.Parse()
+
+		state := server.NewState(server.StateDir())
+		if clear() {
+			if err := state.SetCurrentEnv(""); err != nil {
+				return err
+			}
+			lg.Info("Current env cleared")
+			return nil
+		}
+
+		if len(args) == 0 {
+			return errors.New("no env provide")
+		}
+		profile, err := getProfile(args[0])
+		if err != nil {
+			return err
+		}
+		if err := state.SetCurrentEnv(profile.EnvName); err != nil {
+			return err
+		}
+		lg.Infof("Switched to env %s", profile.EnvName)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(useCmd)
+	useCmd.Flags().Bool("clear", false, "Clear the current env")
+}
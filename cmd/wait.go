@@ -0,0 +1,95 @@
+/*
+Copyright © 2023 Yong
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/superwhys/goutils/flags"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/server"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+)
+
+// pendingReasons formats the pending services as "name: reason" sorted by name
+func pendingReasons(pending map[string]error) string {
+	reasons := make([]string, 0, len(pending))
+	for name, err := range pending {
+		reasons = append(reasons, fmt.Sprintf("%s: %v", name, err))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, "\n")
+}
+
+// waitCmd represents the wait command
+var waitCmd = &cobra.Command{
+	Use:               "wait [--port port] [--timeout 30s] [-l selector] [service...]",
+	Short:             "Wait until the services of the running ssh-proxy are connected and ready",
+	ValidArgsFunction: completeNodes,
+	Long: `Wait until the services of the running ssh-proxy are connected and their local ports accept connections,
+	all the connected services are waited if no services given, e.g.
+
+	ssh-proxy connect --env dev --port 8080 redis mysql &
+	ssh-proxy wait --port 8080 redis mysql
+
+	it exits non-zero with the pending services on timeout, and it keeps waiting while no services are connected
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout := flags.Duration("timeout", 30*time.Second, "")
+		flags.Parse()
+
+		// selector has a shorthand, which is only known by cobra
+		selectors, err := cmd.Flags().GetStringSlice("selector")
+		if err != nil {
+			return err
+		}
+
+		conn, err := dialInstance()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		client := sshproxypb.NewServiceTunnelClient(conn)
+
+		deadline := time.After(timeout())
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			var pending map[string]error
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			resp, err := client.GetConnectNodes(ctx, &sshproxypb.GetConnectNodesRequest{
+				Selector: strings.Join(selectors, ","),
+			})
+			cancel()
+			if err != nil {
+				pending = map[string]error{fmt.Sprintf("ssh-proxy on port %d", port()): err}
+			} else {
+				pending = server.PendingServices(resp.GetConnectedNodes(), args, time.Second)
+			}
+
+			if len(pending) == 0 {
+				lg.Infof("%d services are ready", len(resp.GetConnectedNodes()))
+				return nil
+			}
+
+			select {
+			case <-deadline:
+				return fmt.Errorf("timeout after %v, services not ready:\n%s", timeout(), pendingReasons(pending))
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(waitCmd)
+	waitCmd.Flags().Duration("timeout", 30*time.Second, "Timeout of waiting")
+	waitCmd.Flags().StringSliceP("selector", "l", nil, "Only wait the services matched by the label selector")
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+)
+
+// DefaultEnvVarFormat names the env var of a service, e.g. redis to REDIS_ADDR
+const DefaultEnvVarFormat = "%s_ADDR"
+
+const (
+	dotenvBegin = "# BEGIN ssh-proxy"
+	dotenvEnd   = "# END ssh-proxy"
+)
+
+// EnvVarName derives the env var name of a service from the format,
+// the name is upper cased and the invalid characters are replaced with _,
+// e.g. redis-master:6379 with %s_ADDR is REDIS_MASTER_6379_ADDR
+func EnvVarName(format, serviceName string) string {
+	name := strings.ToUpper(fmt.Sprintf(format, serviceName))
+
+	var b strings.Builder
+	for _, r := range name {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else if b.Len() > 0 && !strings.HasSuffix(b.String(), "_") {
+			b.WriteRune('_')
+		}
+	}
+	name = strings.TrimSuffix(b.String(), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// NodeLocalAddr returns the address to reach the node from local,
+// the node listening on all interfaces is reached by the loopback
+func NodeLocalAddr(node *sshproxypb.Node) string {
+	host, port, err := net.SplitHostPort(node.GetLocalAddress())
+	if err != nil {
+		return node.GetLocalAddress()
+	}
+	if host == "" || host == "::" || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// NodeEnvVars maps the env var names of the nodes to their local addresses,
+// the first node in the order of service name wins if the names conflict
+func NodeEnvVars(format string, nodes []*sshproxypb.Node) map[string]string {
+	sorted := append([]*sshproxypb.Node(nil), nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].GetServiceName() < sorted[j].GetServiceName()
+	})
+
+	vars := make(map[string]string, len(sorted))
+	for _, node := range sorted {
+		name := EnvVarName(format, node.GetServiceName())
+		if name == "" {
+			continue
+		}
+		if _, exists := vars[name]; exists {
+			continue
+		}
+		vars[name] = NodeLocalAddr(node)
+	}
+	return vars
+}
+
+// shellQuote quotes the value for the shell if it has characters other than the safe ones,
+// e.g. the brackets of an ipv6 address would be a glob
+func shellQuote(value string) string {
+	if value != "" && strings.Trim(value, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") == "" {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// FormatEnvVars renders the vars sorted by name, one NAME=value per line,
+// the values are quoted for the shell, so with export they can be eval by the shell
+func FormatEnvVars(vars map[string]string, export bool) string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		if export {
+			b.WriteString("export ")
+		}
+		fmt.Fprintf(&b, "%s=%s\n", name, shellQuote(vars[name]))
+	}
+	return b.String()
+}
+
+// UpdateDotenv writes the vars to the ssh-proxy block of the dotenv file,
+// the other lines of the file are kept
+func UpdateDotenv(path string, vars map[string]string) error {
+	origin, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "read dotenv file")
+	}
+
+	block := dotenvBegin + "\n" + FormatEnvVars(vars, false) + dotenvEnd + "\n"
+
+	var before, after []byte
+	if begin := bytes.Index(origin, []byte(dotenvBegin)); begin >= 0 {
+		before = origin[:begin]
+		if end := bytes.Index(origin[begin:], []byte(dotenvEnd)); end >= 0 {
+			after = bytes.TrimPrefix(origin[begin+end+len(dotenvEnd):], []byte("\n"))
+		}
+	} else {
+		before = origin
+		if len(before) > 0 && !bytes.HasSuffix(before, []byte("\n")) {
+			before = append(before, '\n')
+		}
+	}
+
+	data := append(append(append([]byte{}, before...), block...), after...)
+
+	perm := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return WriteFileAtomic(path, data, perm)
+}
+
+// RenderArgs renders the templates in the args with the vars,
+// e.g. {{.REDIS_ADDR}} is replaced with the address of redis
+func RenderArgs(args []string, vars map[string]string) ([]string, error) {
+	rendered := make([]string, 0, len(args))
+	for _, arg := range args {
+		r, err := renderTemplate(arg, vars)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, r)
+	}
+	return rendered, nil
+}
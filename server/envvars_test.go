@@ -0,0 +1,107 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+)
+
+func TestEnvVarName(t *testing.T) {
+	tests := []struct {
+		format      string
+		serviceName string
+		want        string
+	}{
+		{DefaultEnvVarFormat, "redis", "REDIS_ADDR"},
+		{DefaultEnvVarFormat, "redis-master:6379", "REDIS_MASTER_6379_ADDR"},
+		{DefaultEnvVarFormat, "10.0.0.5:3306", "_10_0_0_5_3306_ADDR"},
+		{"DEV_%s", "api.v2", "DEV_API_V2"},
+		{"%s", "--", ""},
+	}
+	for _, tt := range tests {
+		if got := EnvVarName(tt.format, tt.serviceName); got != tt.want {
+			t.Errorf("EnvVarName(%q, %q) = %q, want %q", tt.format, tt.serviceName, got, tt.want)
+		}
+	}
+}
+
+func TestNodeEnvVars(t *testing.T) {
+	nodes := []*sshproxypb.Node{
+		{ServiceName: "redis", LocalAddress: "[::]:34567"},
+		{ServiceName: "mysql", LocalAddress: "127.0.0.1:3306"},
+		{ServiceName: "Redis", LocalAddress: "[::]:34568"},
+	}
+	want := map[string]string{
+		"REDIS_ADDR": "127.0.0.1:34568",
+		"MYSQL_ADDR": "127.0.0.1:3306",
+	}
+	if got := NodeEnvVars(DefaultEnvVarFormat, nodes); !reflect.DeepEqual(got, want) {
+		t.Errorf("NodeEnvVars() = %v, want %v", got, want)
+	}
+
+	if got, want := FormatEnvVars(want, true), "export MYSQL_ADDR=127.0.0.1:3306\nexport REDIS_ADDR=127.0.0.1:34568\n"; got != want {
+		t.Errorf("FormatEnvVars() = %q, want %q", got, want)
+	}
+}
+
+func TestUpdateDotenv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("DEBUG=1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateDotenv(path, map[string]string{"REDIS_ADDR": "127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateDotenv(path, map[string]string{"MYSQL_ADDR": "127.0.0.1:2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DEBUG=1\n# BEGIN ssh-proxy\nMYSQL_ADDR=127.0.0.1:2\n# END ssh-proxy\n"
+	if string(b) != want {
+		t.Errorf("UpdateDotenv() = %q, want %q", b, want)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+		t.Errorf("UpdateDotenv() mode = %v, want 0644", info.Mode().Perm())
+	}
+}
+
+func TestRenderArgs(t *testing.T) {
+	vars := map[string]string{"REDIS_ADDR": "127.0.0.1:34567"}
+
+	got, err := RenderArgs([]string{"redis-cli", "-u", "redis://{{.REDIS_ADDR}}/0"}, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"redis-cli", "-u", "redis://127.0.0.1:34567/0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RenderArgs() = %v, want %v", got, want)
+	}
+
+	if _, err := RenderArgs([]string{"{{.MYSQL_ADDR}}"}, vars); err == nil {
+		t.Error("RenderArgs() should fail with unknown var")
+	}
+}
+
+func TestFormatEnvVars(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"127.0.0.1:3306", "A=127.0.0.1:3306\n"},
+		{"[::1]:3306", "A='[::1]:3306'\n"},
+		{"", "A=''\n"},
+		{"a b", "A='a b'\n"},
+		{"it's $HOME", `A='it'\''s $HOME'` + "\n"},
+	}
+	for _, tt := range tests {
+		if got := FormatEnvVars(map[string]string{"A": tt.value}, false); got != tt.want {
+			t.Errorf("FormatEnvVars(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
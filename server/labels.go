@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FormatLabels formats labels as a sorted "key=value,key=value" string,
+// which is used as the tag of the node
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// ParseLabels parses "key=value" pairs, each item may also contain
+// multiple pairs separated by comma
+func ParseLabels(items ...string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, item := range items {
+		for _, pair := range strings.Split(item, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok || k == "" {
+				return nil, fmt.Errorf("invalid label %q, it should be key=value", pair)
+			}
+			labels[k] = v
+		}
+	}
+	return labels, nil
+}
+
+type requirement struct {
+	key    string
+	value  string
+	op     string
+	exists bool
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	v, exists := labels[r.key]
+	switch r.op {
+	case "=":
+		return exists && v == r.value
+	case "!=":
+		return !exists || v != r.value
+	default:
+		return exists == r.exists
+	}
+}
+
+// Selector selects services by labels, all its requirements must match
+type Selector []requirement
+
+// ParseSelector parses selectors like "tier=db,team!=payments,canary,!legacy",
+// a bare key requires the label to exist and !key requires it not to
+func ParseSelector(items ...string) (Selector, error) {
+	var selector Selector
+	for _, item := range items {
+		for _, expr := range strings.Split(item, ",") {
+			if expr = strings.TrimSpace(expr); expr == "" {
+				continue
+			}
+
+			var r requirement
+			switch {
+			case strings.Contains(expr, "!="):
+				k, v, _ := strings.Cut(expr, "!=")
+				r = requirement{key: k, value: v, op: "!="}
+			case strings.Contains(expr, "="):
+				k, v, _ := strings.Cut(expr, "=")
+				r = requirement{key: k, value: v, op: "="}
+			case strings.HasPrefix(expr, "!"):
+				r = requirement{key: expr[1:], exists: false}
+			default:
+				r = requirement{key: expr, exists: true}
+			}
+			if r.key == "" {
+				return nil, fmt.Errorf("invalid selector %q", expr)
+			}
+			selector = append(selector, r)
+		}
+	}
+	return selector, nil
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestSelector_Matches(t *testing.T) {
+	labels := map[string]string{"tier": "db", "team": "payments", "canary": ""}
+	tests := []struct {
+		name     string
+		selector string
+		want     bool
+	}{
+		{name: "empty", selector: "", want: true},
+		{name: "equal", selector: "tier=db", want: true},
+		{name: "equal-multi", selector: "tier=db,team=payments", want: true},
+		{name: "equal-mismatch", selector: "tier=web", want: false},
+		{name: "not-equal", selector: "team!=search", want: true},
+		{name: "not-equal-mismatch", selector: "tier=db,team!=payments", want: false},
+		{name: "exists", selector: "canary", want: true},
+		{name: "not-exists", selector: "!legacy", want: true},
+		{name: "not-exists-mismatch", selector: "!canary", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := selector.Matches(labels); got != tt.want {
+				t.Errorf("Selector.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	labels, err := ParseLabels("tier=db", "team=payments,region=eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := FormatLabels(labels), "region=eu,team=payments,tier=db"; got != want {
+		t.Errorf("FormatLabels() = %v, want %v", got, want)
+	}
+	if _, err := ParseLabels("tier"); err == nil {
+		t.Error("ParseLabels() should fail without value")
+	}
+}
@@ -1,131 +1,234 @@
 package server
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
-	"io"
 	"os"
+	"os/user"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/superwhys/goutils/lg"
 )
 
 var (
-	meshFile = fmt.Sprintf("%v/.ssh-proxy-mesh.json", os.Getenv("HOME"))
-	rwcFlag  = os.O_RDWR | os.O_CREATE
+	timeNow = time.Now
 )
 
 type ServiceMesh struct {
+	store MeshStore
 }
 
 type Service struct {
-	ServiceName string
-	RemoteAddr  string
+	ServiceName string `yaml:"serviceName"`
+	RemoteAddr  string `yaml:"remoteAddr"`
+	// Env is the env used to reach the service,
+	// default to the env of the mesh if empty
+	Env string `yaml:"env,omitempty" json:",omitempty"`
+	// Labels are used to select services on connect, e.g. tier=db
+	Labels map[string]string `yaml:"labels,omitempty" json:",omitempty"`
 }
 
 type Mesh struct {
-	Name     string
-	Env      string
-	Services []Service
+	Name     string    `yaml:"name"`
+	Env      string    `yaml:"env"`
+	Services []Service `yaml:"services"`
+	// Includes are the names of other meshes whose services are
+	// also connected with this mesh
+	Includes []string `yaml:"includes,omitempty" json:",omitempty"`
+	// Vars are the default values of the variables used in
+	// the env and service addresses, e.g. api.dev-{{.shard}}.internal:8080
+	Vars map[string]string `yaml:"vars,omitempty" json:",omitempty"`
+
+	// Owner is the user who created the mesh, meshes without owner belong to everyone
+	Owner       string    `yaml:"owner,omitempty" json:",omitempty"`
+	Description string    `yaml:"description,omitempty" json:",omitempty"`
+	CreatedAt   time.Time `yaml:"createdAt,omitempty"`
+	UpdatedAt   time.Time `yaml:"updatedAt,omitempty"`
 }
 
-func NewServiceMesh() *ServiceMesh {
-	return &ServiceMesh{}
-}
+// ConflictPolicy decides what to do when an imported mesh already exists
+type ConflictPolicy string
 
-func (s *ServiceMesh) openMeshFile(flag int) *os.File {
-	file, err := os.OpenFile(meshFile, flag, 0666)
-	lg.PanicError(err)
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictRename    ConflictPolicy = "rename"
+)
 
-	return file
+type ImportResult struct {
+	Name string
+	// ImportedAs is the name of the mesh in the store,
+	// it is empty if the mesh was skipped
+	ImportedAs string
+	Action     string
 }
 
-func (s *ServiceMesh) writeMeshFile(file *os.File, data []byte) error {
-	lg.PanicError(file.Truncate(0))
-
-	_, err := file.Seek(0, 0)
-	if err != nil {
-		return errors.Wrap(err, "seek file error")
+// Validate checks a mesh from outside before saving it
+func (m *Mesh) Validate() error {
+	if m.Name == "" {
+		return errors.New("mesh name is required")
+	}
+	if m.Env == "" {
+		return fmt.Errorf("mesh %s: env is required", m.Name)
 	}
 
-	_, err = file.Write(data)
-	if err != nil {
-		return errors.Wrap(err, "write file error")
+	for _, service := range m.Services {
+		if err := validateServiceAddr(service.RemoteAddr); err != nil {
+			return errors.Wrapf(err, "mesh %s service %s", m.Name, service.ServiceName)
+		}
 	}
 
 	return nil
 }
 
-func (s *ServiceMesh) getAllMeshes(file *os.File) ([]byte, error) {
-	return io.ReadAll(file)
+// GetEnv returns the env of the service in the mesh
+func (m *Mesh) GetEnv(service Service) string {
+	if service.Env != "" {
+		return service.Env
+	}
+	return m.Env
+}
+
+// GroupServicesByEnv groups the services by the env used to reach them
+func (m *Mesh) GroupServicesByEnv() map[string][]Service {
+	groups := make(map[string][]Service)
+	for _, service := range m.Services {
+		env := m.GetEnv(service)
+		groups[env] = append(groups[env], service)
+	}
+	return groups
 }
 
-func (s *ServiceMesh) parseMeshesByte(b []byte) ([]Mesh, error) {
-	if len(b) == 0 {
-		return nil, nil
+func renderTemplate(text string, vars map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
 	}
 
-	var meshes []Mesh
-	err := json.Unmarshal(b, &meshes)
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse meshes error")
+		return "", errors.Wrapf(err, "parse template %q", text)
 	}
 
-	return meshes, nil
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, vars); err != nil {
+		return "", errors.Wrapf(err, "render template %q", text)
+	}
+	return buf.String(), nil
 }
 
-func (s *ServiceMesh) GetAllMeshes() ([]Mesh, error) {
-	file := s.openMeshFile(rwcFlag)
-	defer file.Close()
+// Render returns a copy of the mesh with the variables in its env and services rendered,
+// vars override the default values of the mesh
+func (m *Mesh) Render(vars map[string]string) (*Mesh, error) {
+	merged := make(map[string]string, len(m.Vars)+len(vars))
+	for k, v := range m.Vars {
+		merged[k] = v
+	}
+	for k, v := range vars {
+		merged[k] = v
+	}
+
+	rendered := *m
+	rendered.Services = nil
+	rendered.Vars = merged
+
+	var err error
+	if rendered.Env, err = renderTemplate(m.Env, merged); err != nil {
+		return nil, err
+	}
+	for _, service := range m.Services {
+		if service.ServiceName, err = renderTemplate(service.ServiceName, merged); err != nil {
+			return nil, err
+		}
+		if service.RemoteAddr, err = renderTemplate(service.RemoteAddr, merged); err != nil {
+			return nil, err
+		}
+		if service.Env, err = renderTemplate(service.Env, merged); err != nil {
+			return nil, err
+		}
+		rendered.Services = append(rendered.Services, service)
+	}
+
+	return &rendered, nil
+}
+
+// Filter returns a copy of the mesh with only the services matched by the selector,
+// only and exclude are service names, an empty only means all services
+func (m *Mesh) Filter(selector Selector, only, exclude []string) *Mesh {
+	filtered := *m
+	filtered.Services = nil
+	for _, service := range m.Services {
+		if len(only) > 0 && !containsString(only, service.ServiceName) {
+			continue
+		}
+		if containsString(exclude, service.ServiceName) {
+			continue
+		}
+		if !selector.Matches(service.Labels) {
+			continue
+		}
+		filtered.Services = append(filtered.Services, service)
+	}
+	return &filtered
+}
+
+func NewServiceMesh(store MeshStore) *ServiceMesh {
+	return &ServiceMesh{store: store}
+}
 
-	b, err := s.getAllMeshes(file)
+func (s *ServiceMesh) GetAllMeshes() ([]Mesh, error) {
+	meshes, err := s.store.Load()
 	if err != nil {
 		return nil, errors.Wrap(err, "get all meshes error")
 	}
-	return s.parseMeshesByte(b)
+	return meshes, nil
 }
 
 func (s *ServiceMesh) CreateMesh(mesh Mesh) error {
-	file := s.openMeshFile(rwcFlag)
-	defer file.Close()
+	return s.store.Update(func(meshes []Mesh) ([]Mesh, error) {
+		for _, m := range meshes {
+			if m.Name == mesh.Name {
+				lg.Infof("mesh: %v already exists", mesh.Name)
+				return nil, errMeshUnchanged
+			}
+		}
 
-	b, err := s.getAllMeshes(file)
-	if err != nil {
-		return errors.Wrap(err, "get all meshes error")
-	}
-	meshes, err := s.parseMeshesByte(b)
+		mesh.CreatedAt = timeNow()
+		mesh.UpdatedAt = mesh.CreatedAt
+		return append(meshes, mesh), nil
+	})
+}
+
+// GetUserMeshes returns the meshes of the owner and the ones without owner
+func (s *ServiceMesh) GetUserMeshes(owner string) ([]Mesh, error) {
+	meshes, err := s.GetAllMeshes()
 	if err != nil {
-		return errors.Wrap(err, "parse meshes error")
+		return nil, err
 	}
 
+	var userMeshes []Mesh
 	for _, m := range meshes {
-		if m.Name == mesh.Name {
-			lg.Infof("mesh: %v already exists", mesh.Name)
-			return nil
+		if m.Owner == "" || m.Owner == owner {
+			userMeshes = append(userMeshes, m)
 		}
 	}
+	return userMeshes, nil
+}
 
-	meshes = append(meshes, mesh)
-
-	b, err = json.Marshal(meshes)
-	if err != nil {
-		return errors.Wrap(err, "marshal meshes error")
+// CurrentUser is the owner of the meshes created or imported by this user
+func CurrentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
 	}
-
-	return s.writeMeshFile(file, b)
+	return os.Getenv("USER")
 }
 
 func (s *ServiceMesh) GetMesh(name string) (*Mesh, error) {
-	file := s.openMeshFile(rwcFlag)
-	defer file.Close()
-
-	originBytes, err := s.getAllMeshes(file)
-	if err != nil {
-		return nil, errors.Wrap(err, "get all meshes error")
-	}
-	meshes, err := s.parseMeshesByte(originBytes)
+	meshes, err := s.GetAllMeshes()
 	if err != nil {
-		return nil, errors.Wrap(err, "parse meshes error")
+		return nil, err
 	}
 
 	for _, m := range meshes {
@@ -137,85 +240,359 @@ func (s *ServiceMesh) GetMesh(name string) (*Mesh, error) {
 	return nil, errors.New("mesh not exists")
 }
 
-func (s *ServiceMesh) AddServiceToMesh(meshName string, service *Service) error {
-	file := s.openMeshFile(rwcFlag)
-	defer file.Close()
+// AddServiceToMesh appends the services to the mesh in a single store update,
+// the services already in the mesh are skipped
+func (s *ServiceMesh) AddServiceToMesh(meshName string, services ...*Service) error {
+	return s.updateMesh(meshName, func(mesh *Mesh, _ []Mesh) error {
+		changed := false
+		for _, service := range services {
+			if hasService(mesh, *service) {
+				lg.Infof("service: %v of env %v already exists", service.RemoteAddr, mesh.GetEnv(*service))
+				continue
+			}
+			mesh.Services = append(mesh.Services, *service)
+			changed = true
+		}
+		if !changed {
+			return errMeshUnchanged
+		}
+		return nil
+	})
+}
 
-	b, err := s.getAllMeshes(file)
-	if err != nil {
-		return errors.Wrap(err, "get all meshes error")
-	}
-	meshes, err := s.parseMeshesByte(b)
-	if err != nil {
-		return errors.Wrap(err, "parse meshes error")
+// hasService reports whether the mesh has the service,
+// the same address in another env is another service
+func hasService(mesh *Mesh, service Service) bool {
+	for _, srv := range mesh.Services {
+		if srv.RemoteAddr == service.RemoteAddr && mesh.GetEnv(srv) == mesh.GetEnv(service) {
+			return true
+		}
 	}
+	return false
+}
 
-	var mesh *Mesh
-	for i, m := range meshes {
-		if m.Name == meshName {
-			mesh = &meshes[i]
-			break
+// updateMesh runs fn on the named mesh in a single store update,
+// meshes are all the meshes in the store including the named one
+func (s *ServiceMesh) updateMesh(meshName string, fn func(mesh *Mesh, meshes []Mesh) error) error {
+	return s.store.Update(func(meshes []Mesh) ([]Mesh, error) {
+		for i, m := range meshes {
+			if m.Name == meshName {
+				if err := fn(&meshes[i], meshes); err != nil {
+					return nil, err
+				}
+				meshes[i].UpdatedAt = timeNow()
+				return meshes, nil
+			}
 		}
-	}
 
-	if mesh == nil {
-		lg.Infof("mesh: %v not exists", service.ServiceName)
-		return errors.New("mesh not exists")
-	}
+		lg.Infof("mesh: %v not exists", meshName)
+		return nil, errors.New("mesh not exists")
+	})
+}
 
-	for _, srv := range mesh.Services {
-		if srv.RemoteAddr == service.RemoteAddr {
-			lg.Infof("service: %v already exists", service.RemoteAddr)
-			return nil
+func findService(mesh *Mesh, service string) int {
+	for i, srv := range mesh.Services {
+		if srv.ServiceName == service || srv.RemoteAddr == service {
+			return i
 		}
 	}
+	return -1
+}
 
-	mesh.Services = append(mesh.Services, *service)
-	b, err = json.Marshal(meshes)
-	if err != nil {
-		return errors.Wrap(err, "marshal meshes error")
-	}
+// RemoveServiceFromMesh removes the service matched by its name or remote address
+func (s *ServiceMesh) RemoveServiceFromMesh(meshName string, service string) error {
+	return s.updateMesh(meshName, func(mesh *Mesh, _ []Mesh) error {
+		idx := findService(mesh, service)
+		if idx == -1 {
+			return fmt.Errorf("service: %v not exists in mesh: %v", service, meshName)
+		}
+
+		mesh.Services = append(mesh.Services[:idx], mesh.Services[idx+1:]...)
+		return nil
+	})
+}
+
+func (s *ServiceMesh) RenameService(meshName, service, newName string) error {
+	return s.updateMesh(meshName, func(mesh *Mesh, _ []Mesh) error {
+		idx := findService(mesh, service)
+		if idx == -1 {
+			return fmt.Errorf("service: %v not exists in mesh: %v", service, meshName)
+		}
+		for i, srv := range mesh.Services {
+			if i != idx && srv.ServiceName == newName {
+				return fmt.Errorf("service: %v already exists in mesh: %v", newName, meshName)
+			}
+		}
+
+		mesh.Services[idx].ServiceName = newName
+		return nil
+	})
+}
+
+// LabelService sets labels on the service and removes the labels of the given keys
+func (s *ServiceMesh) LabelService(meshName, service string, labels map[string]string, removes []string) error {
+	return s.updateMesh(meshName, func(mesh *Mesh, _ []Mesh) error {
+		idx := findService(mesh, service)
+		if idx == -1 {
+			return fmt.Errorf("service: %v not exists in mesh: %v", service, meshName)
+		}
+
+		srv := &mesh.Services[idx]
+		if srv.Labels == nil {
+			srv.Labels = make(map[string]string)
+		}
+		for k, v := range labels {
+			srv.Labels[k] = v
+		}
+		for _, k := range removes {
+			delete(srv.Labels, k)
+		}
+		if len(srv.Labels) == 0 {
+			srv.Labels = nil
+		}
+		return nil
+	})
+}
 
-	return s.writeMeshFile(file, b)
+func (s *ServiceMesh) RenameMesh(name, newName string) error {
+	return s.updateMesh(name, func(mesh *Mesh, meshes []Mesh) error {
+		for _, m := range meshes {
+			if m.Name == newName {
+				return fmt.Errorf("mesh: %v already exists", newName)
+			}
+		}
+
+		mesh.Name = newName
+		return nil
+	})
+}
+
+func (s *ServiceMesh) SetMeshEnv(meshName, env string) error {
+	return s.updateMesh(meshName, func(mesh *Mesh, _ []Mesh) error {
+		mesh.Env = env
+		return nil
+	})
+}
+
+// UpdateMesh replaces the named mesh with the given one,
+// which may also rename it, it fails if the includes of the new mesh make a cycle
+func (s *ServiceMesh) UpdateMesh(name string, newMesh Mesh) error {
+	return s.updateMesh(name, func(mesh *Mesh, meshes []Mesh) error {
+		if newMesh.Name != name {
+			for _, m := range meshes {
+				if m.Name == newMesh.Name {
+					return fmt.Errorf("mesh: %v already exists", newMesh.Name)
+				}
+			}
+		}
+
+		newMesh.CreatedAt = mesh.CreatedAt
+		*mesh = newMesh
+		_, err := expandMesh(meshes, newMesh.Name)
+		return err
+	})
 }
 
 func (s *ServiceMesh) DeleteMesh(name string) error {
-	file := s.openMeshFile(rwcFlag)
-	defer file.Close()
+	return s.store.Update(func(meshes []Mesh) ([]Mesh, error) {
+		for i, m := range meshes {
+			if m.Name == name {
+				return append(meshes[:i], meshes[i+1:]...), nil
+			}
+		}
+
+		lg.Infof("mesh: %v not exists", name)
+		return nil, errors.New("mesh not exists")
+	})
+}
+
+// ImportMeshes merges meshes into the store in a single update
+func (s *ServiceMesh) ImportMeshes(imports []Mesh, policy ConflictPolicy) ([]ImportResult, error) {
+	switch policy {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy: %v", policy)
+	}
+
+	var results []ImportResult
+	err := s.store.Update(func(meshes []Mesh) ([]Mesh, error) {
+		results = nil
+		index := make(map[string]int, len(meshes))
+		for i, m := range meshes {
+			index[m.Name] = i
+		}
 
-	b, err := s.getAllMeshes(file)
+		for _, mesh := range imports {
+			result := ImportResult{Name: mesh.Name, ImportedAs: mesh.Name, Action: "created"}
+			mesh.UpdatedAt = timeNow()
+			if mesh.CreatedAt.IsZero() {
+				mesh.CreatedAt = mesh.UpdatedAt
+			}
+
+			if i, exists := index[mesh.Name]; exists {
+				switch policy {
+				case ConflictSkip:
+					result.ImportedAs = ""
+					result.Action = "skipped"
+				case ConflictOverwrite:
+					meshes[i] = mesh
+					result.Action = "overwritten"
+				case ConflictRename:
+					for n := 1; ; n++ {
+						name := fmt.Sprintf("%v-%d", mesh.Name, n)
+						if _, exists := index[name]; !exists {
+							mesh.Name = name
+							break
+						}
+					}
+					result.ImportedAs = mesh.Name
+					result.Action = "renamed"
+				}
+			}
+
+			if result.Action == "created" || result.Action == "renamed" {
+				index[mesh.Name] = len(meshes)
+				meshes = append(meshes, mesh)
+			}
+			results = append(results, result)
+		}
+
+		return meshes, nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "get all meshes error")
+		return nil, err
 	}
-	meshes, err := s.parseMeshesByte(b)
+
+	return results, nil
+}
+
+// ExpandMesh returns the mesh with the services of its includes resolved recursively.
+// Services are de-duplicated by env and remote address, the first one found wins,
+// so the services of the mesh itself take precedence over the included ones.
+// Vars are merged in the same way and left unrendered.
+func (s *ServiceMesh) ExpandMesh(name string) (*Mesh, error) {
+	meshes, err := s.GetAllMeshes()
 	if err != nil {
-		return errors.Wrap(err, "parse meshes error")
+		return nil, err
 	}
+	return expandMesh(meshes, name)
+}
 
-	var mesh *Mesh
-	for i, m := range meshes {
-		if m.Name == name {
-			mesh = &meshes[i]
-			break
-		}
+func expandMesh(meshes []Mesh, name string) (*Mesh, error) {
+	byName := make(map[string]Mesh, len(meshes))
+	for _, m := range meshes {
+		byName[m.Name] = m
 	}
 
-	if mesh == nil {
-		lg.Infof("mesh: %v not exists", name)
-		return errors.New("mesh not exists")
+	root, exists := byName[name]
+	if !exists {
+		return nil, errors.New("mesh not exists")
 	}
 
-	for i, m := range meshes {
-		if m.Name == name {
-			meshes = append(meshes[:i], meshes[i+1:]...)
-			break
+	expanded := root
+	expanded.Services = nil
+	expanded.Vars = make(map[string]string)
+	seen := make(map[string]bool)
+	done := make(map[string]bool)
+
+	var expand func(mesh Mesh, path []string) error
+	expand = func(mesh Mesh, path []string) error {
+		path = append(path, mesh.Name)
+
+		// the vars of the meshes closer to the root win
+		for k, v := range mesh.Vars {
+			if _, exists := expanded.Vars[k]; !exists {
+				expanded.Vars[k] = v
+			}
+		}
+
+		for _, service := range mesh.Services {
+			service.Env = mesh.GetEnv(service)
+			key := service.Env + "/" + service.RemoteAddr
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if service.Env == root.Env {
+				service.Env = ""
+			}
+			expanded.Services = append(expanded.Services, service)
+		}
+
+		for _, include := range mesh.Includes {
+			if containsString(path, include) {
+				return fmt.Errorf("include cycle: %v", strings.Join(append(path, include), " -> "))
+			}
+			if done[include] {
+				continue
+			}
+
+			m, exists := byName[include]
+			if !exists {
+				return fmt.Errorf("mesh: %v includes a not exists mesh: %v", mesh.Name, include)
+			}
+			if err := expand(m, path); err != nil {
+				return err
+			}
 		}
+
+		done[mesh.Name] = true
+		return nil
 	}
 
-	b, err = json.Marshal(meshes)
-	if err != nil {
-		return errors.Wrap(err, "marshal meshes error")
+	if err := expand(root, nil); err != nil {
+		return nil, err
+	}
+	if len(expanded.Vars) == 0 {
+		expanded.Vars = nil
 	}
+	return &expanded, nil
+}
+
+// IncludeMeshes adds includes to the mesh, it fails if that makes an include cycle
+func (s *ServiceMesh) IncludeMeshes(meshName string, includes ...string) error {
+	return s.updateMesh(meshName, func(mesh *Mesh, meshes []Mesh) error {
+		changed := false
+		for _, include := range includes {
+			if containsString(mesh.Includes, include) {
+				lg.Infof("mesh: %v already included", include)
+				continue
+			}
+			mesh.Includes = append(mesh.Includes, include)
+			changed = true
+		}
+		if !changed {
+			return errMeshUnchanged
+		}
+
+		_, err := expandMesh(meshes, meshName)
+		return err
+	})
+}
+
+func (s *ServiceMesh) RemoveIncludes(meshName string, includes ...string) error {
+	return s.updateMesh(meshName, func(mesh *Mesh, _ []Mesh) error {
+		for _, include := range includes {
+			idx := indexString(mesh.Includes, include)
+			if idx == -1 {
+				return fmt.Errorf("mesh: %v is not included in mesh: %v", include, meshName)
+			}
+			mesh.Includes = append(mesh.Includes[:idx], mesh.Includes[idx+1:]...)
+		}
+		return nil
+	})
+}
+
+func indexString(slice []string, s string) int {
+	for i, item := range slice {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
 
-	return s.writeMeshFile(file, b)
+func containsString(slice []string, s string) bool {
+	return indexString(slice, s) != -1
 }
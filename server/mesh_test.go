@@ -1,16 +1,18 @@
 package server
 
 import (
-	"os"
 	"reflect"
 	"testing"
+	"time"
+)
 
-	"github.com/superwhys/goutils/lg"
+var (
+	testStore = NewMemoryMeshStore()
 )
 
-func TestMain(m *testing.M) {
-	lg.PanicError(os.Remove(meshFile))
-	m.Run()
+func init() {
+	// keep the timestamps zero so that meshes can be compared
+	timeNow = func() time.Time { return time.Time{} }
 }
 
 func TestServiceMesh_CreateMesh(t *testing.T) {
@@ -27,7 +29,7 @@ func TestServiceMesh_CreateMesh(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &ServiceMesh{}
+			s := NewServiceMesh(testStore)
 			s.CreateMesh(tt.args.mesh)
 		})
 	}
@@ -48,7 +50,7 @@ func TestServiceMesh_GetAllMeshes(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &ServiceMesh{}
+			s := NewServiceMesh(testStore)
 
 			got, err := s.GetAllMeshes()
 			if err != nil {
@@ -75,7 +77,7 @@ func TestServiceMesh_GetMesh(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &ServiceMesh{}
+			s := NewServiceMesh(testStore)
 			got, err := s.GetMesh(tt.args.name)
 			if err != nil {
 				t.Error(err)
@@ -101,7 +103,7 @@ func TestServiceMesh_AddServiceToMesh(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &ServiceMesh{}
+			s := NewServiceMesh(testStore)
 			s.AddServiceToMesh(tt.args.meshName, tt.args.service)
 		})
 	}
@@ -116,12 +118,406 @@ func TestServiceMesh_DeleteMesh(t *testing.T) {
 		s    *ServiceMesh
 		args args
 	}{
-		{name: "DeleteMesh-1", s: &ServiceMesh{}, args: args{name: "mesh-2"}},
+		{name: "DeleteMesh-1", s: NewServiceMesh(testStore), args: args{name: "mesh-2"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &ServiceMesh{}
+			s := NewServiceMesh(testStore)
 			s.DeleteMesh(tt.args.name)
 		})
 	}
 }
+
+func TestServiceMesh_ImportMeshes(t *testing.T) {
+	existing := Mesh{Name: "mesh-1", Env: "env-1", Services: []Service{{ServiceName: "service-1", RemoteAddr: "remote-addr-1"}}}
+	imported := Mesh{Name: "mesh-1", Env: "env-2", Services: []Service{{ServiceName: "service-2", RemoteAddr: "remote-addr-2"}}}
+	renamed := imported
+	renamed.Name = "mesh-1-1"
+
+	tests := []struct {
+		name   string
+		policy ConflictPolicy
+		want   []Mesh
+	}{
+		{name: "ImportMeshes-skip", policy: ConflictSkip, want: []Mesh{existing}},
+		{name: "ImportMeshes-overwrite", policy: ConflictOverwrite, want: []Mesh{imported}},
+		{name: "ImportMeshes-rename", policy: ConflictRename, want: []Mesh{existing, renamed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServiceMesh(NewMemoryMeshStore(existing))
+			if _, err := s.ImportMeshes([]Mesh{imported}, tt.policy); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.GetAllMeshes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServiceMesh.ImportMeshes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceMesh_EditMesh(t *testing.T) {
+	origin := Mesh{Name: "mesh-1", Env: "env-1", Services: []Service{
+		{ServiceName: "service-1", RemoteAddr: "remote-addr-1"},
+		{ServiceName: "service-2", RemoteAddr: "remote-addr-2"},
+	}}
+	tests := []struct {
+		name    string
+		edit    func(s *ServiceMesh) error
+		want    []Mesh
+		wantErr bool
+	}{
+		{
+			name: "RemoveServiceFromMesh-name",
+			edit: func(s *ServiceMesh) error { return s.RemoveServiceFromMesh("mesh-1", "service-1") },
+			want: []Mesh{{Name: "mesh-1", Env: "env-1", Services: []Service{{ServiceName: "service-2", RemoteAddr: "remote-addr-2"}}}},
+		},
+		{
+			name: "RemoveServiceFromMesh-addr",
+			edit: func(s *ServiceMesh) error { return s.RemoveServiceFromMesh("mesh-1", "remote-addr-2") },
+			want: []Mesh{{Name: "mesh-1", Env: "env-1", Services: []Service{{ServiceName: "service-1", RemoteAddr: "remote-addr-1"}}}},
+		},
+		{
+			name:    "RemoveServiceFromMesh-not-exists",
+			edit:    func(s *ServiceMesh) error { return s.RemoveServiceFromMesh("mesh-1", "service-3") },
+			want:    []Mesh{origin},
+			wantErr: true,
+		},
+		{
+			name: "RenameService",
+			edit: func(s *ServiceMesh) error { return s.RenameService("mesh-1", "service-1", "redis") },
+			want: []Mesh{{Name: "mesh-1", Env: "env-1", Services: []Service{
+				{ServiceName: "redis", RemoteAddr: "remote-addr-1"},
+				{ServiceName: "service-2", RemoteAddr: "remote-addr-2"},
+			}}},
+		},
+		{
+			name:    "RenameService-conflict",
+			edit:    func(s *ServiceMesh) error { return s.RenameService("mesh-1", "service-1", "service-2") },
+			want:    []Mesh{origin},
+			wantErr: true,
+		},
+		{
+			name: "RenameMesh",
+			edit: func(s *ServiceMesh) error { return s.RenameMesh("mesh-1", "mesh-2") },
+			want: []Mesh{{Name: "mesh-2", Env: "env-1", Services: origin.Services}},
+		},
+		{
+			name: "SetMeshEnv",
+			edit: func(s *ServiceMesh) error { return s.SetMeshEnv("mesh-1", "env-2") },
+			want: []Mesh{{Name: "mesh-1", Env: "env-2", Services: origin.Services}},
+		},
+		{
+			name: "UpdateMesh",
+			edit: func(s *ServiceMesh) error { return s.UpdateMesh("mesh-1", Mesh{Name: "mesh-3", Env: "env-3"}) },
+			want: []Mesh{{Name: "mesh-3", Env: "env-3"}},
+		},
+		{
+			name: "UpdateMesh-include-cycle",
+			edit: func(s *ServiceMesh) error {
+				return s.UpdateMesh("mesh-1", Mesh{Name: "mesh-1", Env: "env-1", Includes: []string{"mesh-1"}})
+			},
+			want:    []Mesh{origin},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServiceMesh(NewMemoryMeshStore(origin))
+			if err := tt.edit(s); (err != nil) != tt.wantErr {
+				t.Errorf("edit error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got, err := s.GetAllMeshes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServiceMesh.GetAllMeshes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMesh_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mesh    Mesh
+		wantErr bool
+	}{
+		{"valid", Mesh{Name: "mesh-1", Env: "dev", Services: []Service{{ServiceName: "redis", RemoteAddr: "redis:6379"}}}, false},
+		{"templated", Mesh{Name: "mesh-1", Env: "dev", Services: []Service{{ServiceName: "api", RemoteAddr: "api.{{.shard}}.internal:{{.port}}"}}}, false},
+		{"no name", Mesh{Env: "dev"}, true},
+		{"no env", Mesh{Name: "mesh-1"}, true},
+		{"no port", Mesh{Name: "mesh-1", Env: "dev", Services: []Service{{ServiceName: "redis", RemoteAddr: "redis"}}}, true},
+		{"invalid port", Mesh{Name: "mesh-1", Env: "dev", Services: []Service{{ServiceName: "redis", RemoteAddr: "redis:70000"}}}, true},
+		{"empty host", Mesh{Name: "mesh-1", Env: "dev", Services: []Service{{ServiceName: "redis", RemoteAddr: ":6379"}}}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.mesh.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Mesh.Validate() %s error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMesh_GroupServicesByEnv(t *testing.T) {
+	mesh := &Mesh{Name: "mesh-1", Env: "dev", Services: []Service{
+		{ServiceName: "api", RemoteAddr: "api:8080"},
+		{ServiceName: "auth", RemoteAddr: "auth:443", Env: "staging"},
+		{ServiceName: "web", RemoteAddr: "web:80", Env: "dev"},
+	}}
+	want := map[string][]Service{
+		"dev":     {{ServiceName: "api", RemoteAddr: "api:8080"}, {ServiceName: "web", RemoteAddr: "web:80", Env: "dev"}},
+		"staging": {{ServiceName: "auth", RemoteAddr: "auth:443", Env: "staging"}},
+	}
+
+	if got := mesh.GroupServicesByEnv(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Mesh.GroupServicesByEnv() = %v, want %v", got, want)
+	}
+}
+
+func TestServiceMesh_ExpandMesh(t *testing.T) {
+	meshes := []Mesh{
+		{Name: "infra-base", Env: "dev", Services: []Service{{ServiceName: "redis", RemoteAddr: "redis:6379"}, {ServiceName: "mysql", RemoteAddr: "mysql:3306"}}},
+		{Name: "analytics", Env: "shared", Services: []Service{{ServiceName: "clickhouse", RemoteAddr: "ch:9000"}}, Includes: []string{"infra-base"}},
+		{Name: "product-a", Env: "dev", Services: []Service{{ServiceName: "api", RemoteAddr: "api:8080"}, {ServiceName: "cache", RemoteAddr: "redis:6379"}}, Includes: []string{"infra-base", "analytics"}},
+		{Name: "cycle-a", Env: "dev", Includes: []string{"cycle-b"}},
+		{Name: "cycle-b", Env: "dev", Includes: []string{"cycle-a"}},
+		{Name: "broken", Env: "dev", Includes: []string{"not-exists"}},
+	}
+	tests := []struct {
+		name    string
+		mesh    string
+		want    *Mesh
+		wantErr bool
+	}{
+		{
+			name: "ExpandMesh-1",
+			mesh: "product-a",
+			want: &Mesh{Name: "product-a", Env: "dev", Includes: []string{"infra-base", "analytics"}, Services: []Service{
+				{ServiceName: "api", RemoteAddr: "api:8080"},
+				{ServiceName: "cache", RemoteAddr: "redis:6379"},
+				{ServiceName: "mysql", RemoteAddr: "mysql:3306"},
+				{ServiceName: "clickhouse", RemoteAddr: "ch:9000", Env: "shared"},
+			}},
+		},
+		{name: "ExpandMesh-cycle", mesh: "cycle-a", wantErr: true},
+		{name: "ExpandMesh-not-exists", mesh: "broken", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServiceMesh(NewMemoryMeshStore(meshes...))
+			got, err := s.ExpandMesh(tt.mesh)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ServiceMesh.ExpandMesh() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ServiceMesh.ExpandMesh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceMesh_IncludeMeshes(t *testing.T) {
+	s := NewServiceMesh(NewMemoryMeshStore(
+		Mesh{Name: "mesh-1", Env: "dev"},
+		Mesh{Name: "mesh-2", Env: "dev", Includes: []string{"mesh-1"}},
+	))
+
+	if err := s.IncludeMeshes("mesh-1", "mesh-2"); err == nil {
+		t.Error("ServiceMesh.IncludeMeshes() should fail on include cycle")
+	}
+	if err := s.RemoveIncludes("mesh-2", "mesh-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.IncludeMeshes("mesh-1", "mesh-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetMesh("mesh-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mesh-2"}; !reflect.DeepEqual(got.Includes, want) {
+		t.Errorf("mesh-1 includes = %v, want %v", got.Includes, want)
+	}
+}
+
+func TestMesh_Render(t *testing.T) {
+	mesh := &Mesh{
+		Name: "mesh-1",
+		Env:  "dev-{{.region}}",
+		Vars: map[string]string{"shard": "1", "region": "eu"},
+		Services: []Service{
+			{ServiceName: "api", RemoteAddr: "api.dev-{{.shard}}.internal:8080"},
+			{ServiceName: "auth", RemoteAddr: "auth:443", Env: "staging-{{.region}}"},
+		},
+	}
+	tests := []struct {
+		name    string
+		mesh    *Mesh
+		vars    map[string]string
+		want    *Mesh
+		wantErr bool
+	}{
+		{
+			name: "Render-default",
+			mesh: mesh,
+			want: &Mesh{Name: "mesh-1", Env: "dev-eu", Vars: map[string]string{"shard": "1", "region": "eu"}, Services: []Service{
+				{ServiceName: "api", RemoteAddr: "api.dev-1.internal:8080"},
+				{ServiceName: "auth", RemoteAddr: "auth:443", Env: "staging-eu"},
+			}},
+		},
+		{
+			name: "Render-override",
+			mesh: mesh,
+			vars: map[string]string{"shard": "42"},
+			want: &Mesh{Name: "mesh-1", Env: "dev-eu", Vars: map[string]string{"shard": "42", "region": "eu"}, Services: []Service{
+				{ServiceName: "api", RemoteAddr: "api.dev-42.internal:8080"},
+				{ServiceName: "auth", RemoteAddr: "auth:443", Env: "staging-eu"},
+			}},
+		},
+		{
+			name:    "Render-missing",
+			mesh:    &Mesh{Name: "mesh-2", Env: "dev", Services: []Service{{ServiceName: "api", RemoteAddr: "api.dev-{{.shard}}.internal:8080"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.mesh.Render(tt.vars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Mesh.Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mesh.Render() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMesh_Filter(t *testing.T) {
+	mesh := &Mesh{Name: "mesh-1", Env: "dev", Services: []Service{
+		{ServiceName: "api", RemoteAddr: "api:8080", Labels: map[string]string{"tier": "web"}},
+		{ServiceName: "mysql", RemoteAddr: "mysql:3306", Labels: map[string]string{"tier": "db", "team": "payments"}},
+		{ServiceName: "redis", RemoteAddr: "redis:6379", Labels: map[string]string{"tier": "db"}},
+	}}
+	tests := []struct {
+		name     string
+		selector string
+		only     []string
+		exclude  []string
+		want     []string
+	}{
+		{name: "Filter-all", want: []string{"api", "mysql", "redis"}},
+		{name: "Filter-selector", selector: "tier=db", want: []string{"mysql", "redis"}},
+		{name: "Filter-only", only: []string{"api", "redis"}, want: []string{"api", "redis"}},
+		{name: "Filter-exclude", selector: "tier=db", exclude: []string{"mysql"}, want: []string{"redis"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, service := range mesh.Filter(selector, tt.only, tt.exclude).Services {
+				got = append(got, service.ServiceName)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Mesh.Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceMesh_AddServiceToMesh_Envs(t *testing.T) {
+	s := NewServiceMesh(NewMemoryMeshStore(Mesh{Name: "mesh-1", Env: "dev", Services: []Service{
+		{ServiceName: "redis", RemoteAddr: "redis:6379"},
+	}}))
+
+	if err := s.AddServiceToMesh("mesh-1", &Service{ServiceName: "redis", RemoteAddr: "redis:6379", Env: "staging"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddServiceToMesh("mesh-1", &Service{ServiceName: "redis", RemoteAddr: "redis:6379", Env: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetMesh("mesh-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Service{
+		{ServiceName: "redis", RemoteAddr: "redis:6379"},
+		{ServiceName: "redis", RemoteAddr: "redis:6379", Env: "staging"},
+	}
+	if !reflect.DeepEqual(got.Services, want) {
+		t.Errorf("services = %v, want %v", got.Services, want)
+	}
+}
+
+func TestServiceMesh_LabelService(t *testing.T) {
+	s := NewServiceMesh(NewMemoryMeshStore(Mesh{Name: "mesh-1", Env: "dev", Services: []Service{
+		{ServiceName: "mysql", RemoteAddr: "mysql:3306", Labels: map[string]string{"legacy": "true"}},
+	}}))
+
+	if err := s.LabelService("mesh-1", "mysql", map[string]string{"tier": "db"}, []string{"legacy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetMesh("mesh-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"tier": "db"}; !reflect.DeepEqual(got.Services[0].Labels, want) {
+		t.Errorf("labels = %v, want %v", got.Services[0].Labels, want)
+	}
+}
+
+func TestServiceMesh_Timestamps(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = func() time.Time { return time.Time{} } }()
+
+	s := NewServiceMesh(NewMemoryMeshStore())
+	if err := s.CreateMesh(Mesh{Name: "mesh-1", Env: "dev", Owner: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateMesh(Mesh{Name: "mesh-2", Env: "dev", Owner: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateMesh(Mesh{Name: "mesh-3", Env: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := s.SetMeshEnv("mesh-1", "stg"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetMesh("mesh-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(now.Add(-time.Hour)) || !got.UpdatedAt.Equal(now) {
+		t.Errorf("mesh timestamps = %v, %v", got.CreatedAt, got.UpdatedAt)
+	}
+
+	meshes, err := s.GetUserMeshes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range meshes {
+		names = append(names, m.Name)
+	}
+	if want := []string{"mesh-1", "mesh-3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ServiceMesh.GetUserMeshes() = %v, want %v", names, want)
+	}
+}
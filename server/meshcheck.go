@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/sshtunnel"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	CheckHop     = "hop"
+	CheckService = "service"
+)
+
+// CheckResult is the result of checking one ssh hop or one service of a mesh
+type CheckResult struct {
+	Env     string
+	Kind    string
+	Name    string
+	Address string
+	Latency time.Duration
+	Err     error
+}
+
+func (r CheckResult) OK() bool {
+	return r.Err == nil
+}
+
+// channelDialer opens channels to the services, which is implemented by *ssh.Client
+type channelDialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// dialHops dials the jump chain hop by hop, the returned clients
+// are in the order of the chain and should be closed by the caller
+func dialHops(env string, confs []*sshtunnel.SshConfig, timeout time.Duration) ([]*ssh.Client, []CheckResult) {
+	var clients []*ssh.Client
+	var results []CheckResult
+	for i, conf := range confs {
+		conf.SetDefaults()
+		result := CheckResult{Env: env, Kind: CheckHop, Name: fmt.Sprintf("%d:%s", i+1, conf.User), Address: conf.HostName}
+
+		start := time.Now()
+		client, err := dialHop(clients, conf, timeout)
+		result.Latency = time.Since(start)
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			return clients, results
+		}
+
+		clients = append(clients, client)
+		results = append(results, result)
+	}
+	return clients, results
+}
+
+func dialHop(clients []*ssh.Client, conf *sshtunnel.SshConfig, timeout time.Duration) (*ssh.Client, error) {
+	clientConf, err := conf.ParseClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "parse identity file")
+	}
+	clientConf.Timeout = timeout
+
+	if len(clients) == 0 {
+		return ssh.Dial("tcp", conf.HostName, clientConf)
+	}
+
+	conn, err := dialTimeout(clients[len(clients)-1], conf.HostName, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, conf.HostName, clientConf)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dialTimeout dials through the dialer, ssh.Client has no dial timeout itself
+func dialTimeout(dialer channelDialer, addr string, timeout time.Duration) (net.Conn, error) {
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan dialed, 1)
+	go func() {
+		conn, err := dialer.Dial("tcp", addr)
+		ch <- dialed{conn, err}
+	}()
+
+	select {
+	case d := <-ch:
+		return d.conn, d.err
+	case <-time.After(timeout):
+		go func() {
+			if d := <-ch; d.conn != nil {
+				d.conn.Close()
+			}
+		}()
+		return nil, errors.Errorf("dial %s timeout after %v", addr, timeout)
+	}
+}
+
+// checkServices opens and immediately closes a channel to every service
+func checkServices(dialer channelDialer, env string, services []Service, timeout time.Duration) []CheckResult {
+	results := make([]CheckResult, 0, len(services))
+	for _, service := range services {
+		result := CheckResult{Env: env, Kind: CheckService, Name: service.ServiceName, Address: service.RemoteAddr}
+
+		start := time.Now()
+		conn, err := dialTimeout(dialer, service.RemoteAddr, timeout)
+		result.Latency = time.Since(start)
+		if err != nil {
+			result.Err = err
+		} else {
+			conn.Close()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// CheckEnv dials the jump chain of the env and the services through it
+// without binding any local port, services are skipped if any hop fails
+func CheckEnv(env string, confs []*sshtunnel.SshConfig, services []Service, timeout time.Duration) []CheckResult {
+	if len(confs) == 0 {
+		return []CheckResult{{Env: env, Kind: CheckHop, Err: errors.New("no hosts in the profile")}}
+	}
+
+	clients, results := dialHops(env, confs, timeout)
+	defer func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}()
+
+	if len(clients) < len(confs) {
+		for _, service := range services {
+			results = append(results, CheckResult{
+				Env:     env,
+				Kind:    CheckService,
+				Name:    service.ServiceName,
+				Address: service.RemoteAddr,
+				Err:     errors.New("skipped, the jump chain is not reachable"),
+			})
+		}
+		return results
+	}
+
+	return append(results, checkServices(clients[len(clients)-1], env, services, timeout)...)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/superwhys/sshtunnel"
+)
+
+type blockingDialer struct{}
+
+func (blockingDialer) Dial(network, addr string) (net.Conn, error) {
+	select {}
+}
+
+func TestCheckServices(t *testing.T) {
+	up, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Close()
+
+	services := []Service{
+		{ServiceName: "up", RemoteAddr: up.Addr().String()},
+		{ServiceName: "down", RemoteAddr: down.Addr().String()},
+	}
+	results := checkServices(&net.Dialer{}, "dev", services, time.Second)
+	if len(results) != 2 {
+		t.Fatalf("checkServices() got %d results, want 2", len(results))
+	}
+	if !results[0].OK() || results[0].Kind != CheckService || results[0].Env != "dev" {
+		t.Errorf("checkServices() = %+v, want service up passed", results[0])
+	}
+	if results[1].OK() {
+		t.Errorf("checkServices() = %+v, want service down failed", results[1])
+	}
+
+	results = checkServices(blockingDialer{}, "dev", services[:1], 10*time.Millisecond)
+	if results[0].OK() {
+		t.Errorf("checkServices() = %+v, want timeout", results[0])
+	}
+}
+
+func TestCheckEnv(t *testing.T) {
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Close()
+
+	confs := []*sshtunnel.SshConfig{{HostName: down.Addr().String(), User: "root", IdentityFile: "testdata/not-exists"}}
+	services := []Service{{ServiceName: "redis", RemoteAddr: "redis:6379"}}
+	results := CheckEnv("dev", confs, services, time.Second)
+	if len(results) != 2 {
+		t.Fatalf("CheckEnv() got %d results, want 2", len(results))
+	}
+	for _, r := range results {
+		if r.OK() {
+			t.Errorf("CheckEnv() = %+v, want failed", r)
+		}
+	}
+	if results[0].Kind != CheckHop || results[1].Kind != CheckService {
+		t.Errorf("CheckEnv() = %+v, want hop and then service", results)
+	}
+}
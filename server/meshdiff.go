@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ChangeAction string
+
+const (
+	ChangeAdd    ChangeAction = "add"
+	ChangeRemove ChangeAction = "remove"
+	ChangeUpdate ChangeAction = "change"
+)
+
+// FieldChange is a changed field of the mesh, values are formatted as string
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// ServiceChange is a service added, removed or changed in a mesh,
+// Old is nil for added services and New is nil for removed ones
+type ServiceChange struct {
+	Action ChangeAction
+	Old    *Service
+	New    *Service
+}
+
+// MeshChange is the change of one mesh to reach the desired state
+type MeshChange struct {
+	Action   ChangeAction
+	Name     string
+	Fields   []FieldChange
+	Services []ServiceChange
+}
+
+func diffField(field, old, new string) []FieldChange {
+	if old == new {
+		return nil
+	}
+	return []FieldChange{{Field: field, Old: old, New: new}}
+}
+
+// diffServices matches the services by name and remote address first, then the rest by name in order,
+// so a changed address is an update, and the services sharing a name are not mixed up
+func diffServices(current, desired []Service) []ServiceChange {
+	matched := make([]bool, len(current))
+	match := func(service Service, sameAddr bool) int {
+		for i, c := range current {
+			if !matched[i] && c.ServiceName == service.ServiceName && (!sameAddr || c.RemoteAddr == service.RemoteAddr) {
+				matched[i] = true
+				return i
+			}
+		}
+		return -1
+	}
+
+	olds := make([]int, len(desired))
+	for i, service := range desired {
+		olds[i] = match(service, true)
+	}
+	for i, service := range desired {
+		if olds[i] < 0 {
+			olds[i] = match(service, false)
+		}
+	}
+
+	var changes []ServiceChange
+	for i := range desired {
+		service := desired[i]
+		if olds[i] < 0 {
+			changes = append(changes, ServiceChange{Action: ChangeAdd, New: &service})
+			continue
+		}
+		if old := current[olds[i]]; !serviceEqual(old, service) {
+			changes = append(changes, ServiceChange{Action: ChangeUpdate, Old: &old, New: &service})
+		}
+	}
+
+	for i := range current {
+		service := current[i]
+		if !matched[i] {
+			changes = append(changes, ServiceChange{Action: ChangeRemove, Old: &service})
+		}
+	}
+	return changes
+}
+
+func serviceEqual(a, b Service) bool {
+	return a.RemoteAddr == b.RemoteAddr && a.Env == b.Env && FormatLabels(a.Labels) == FormatLabels(b.Labels)
+}
+
+// diffMesh compares the fields defined by users, the owner is only
+// compared if the desired mesh has one
+func diffMesh(current, desired Mesh) MeshChange {
+	change := MeshChange{Action: ChangeUpdate, Name: desired.Name}
+
+	var fields []FieldChange
+	fields = append(fields, diffField("env", current.Env, desired.Env)...)
+	fields = append(fields, diffField("description", current.Description, desired.Description)...)
+	fields = append(fields, diffField("includes", strings.Join(current.Includes, ","), strings.Join(desired.Includes, ","))...)
+	fields = append(fields, diffField("vars", FormatLabels(current.Vars), FormatLabels(desired.Vars))...)
+	if desired.Owner != "" {
+		fields = append(fields, diffField("owner", current.Owner, desired.Owner)...)
+	}
+	change.Fields = fields
+	change.Services = diffServices(current.Services, desired.Services)
+	return change
+}
+
+// DiffMeshes computes the changes to turn current meshes into the desired ones,
+// the meshes not desired are only removed if prune is set
+func DiffMeshes(current, desired []Mesh, prune bool) []MeshChange {
+	var changes []MeshChange
+	currentByName := make(map[string]Mesh, len(current))
+	for _, mesh := range current {
+		currentByName[mesh.Name] = mesh
+	}
+
+	desiredNames := make(map[string]bool, len(desired))
+	for _, mesh := range desired {
+		desiredNames[mesh.Name] = true
+
+		old, exists := currentByName[mesh.Name]
+		if !exists {
+			changes = append(changes, MeshChange{
+				Action:   ChangeAdd,
+				Name:     mesh.Name,
+				Services: diffServices(nil, mesh.Services),
+			})
+			continue
+		}
+
+		change := diffMesh(old, mesh)
+		if len(change.Fields) > 0 || len(change.Services) > 0 {
+			changes = append(changes, change)
+		}
+	}
+
+	if prune {
+		for _, mesh := range current {
+			if !desiredNames[mesh.Name] {
+				changes = append(changes, MeshChange{
+					Action:   ChangeRemove,
+					Name:     mesh.Name,
+					Services: diffServices(mesh.Services, nil),
+				})
+			}
+		}
+	}
+	return changes
+}
+
+// ApplyMeshes reconciles the store into the desired meshes in a single update
+// and returns the applied changes, the desired meshes should be validated before
+func (s *ServiceMesh) ApplyMeshes(desired []Mesh, prune bool) ([]MeshChange, error) {
+	names := make(map[string]bool, len(desired))
+	for _, mesh := range desired {
+		if names[mesh.Name] {
+			return nil, fmt.Errorf("mesh: %v is defined more than once", mesh.Name)
+		}
+		names[mesh.Name] = true
+	}
+
+	var changes []MeshChange
+	err := s.store.Update(func(meshes []Mesh) ([]Mesh, error) {
+		changes = DiffMeshes(meshes, desired, prune)
+		if len(changes) == 0 {
+			return nil, errMeshUnchanged
+		}
+
+		desiredByName := make(map[string]Mesh, len(desired))
+		for _, mesh := range desired {
+			desiredByName[mesh.Name] = mesh
+		}
+		changed := make(map[string]bool, len(changes))
+		for _, change := range changes {
+			changed[change.Name] = true
+		}
+
+		now := timeNow()
+		applied := make([]Mesh, 0, len(meshes))
+		for _, mesh := range meshes {
+			d, exists := desiredByName[mesh.Name]
+			switch {
+			case !exists && prune:
+				continue
+			case !exists:
+				applied = append(applied, mesh)
+				continue
+			}
+
+			if !changed[mesh.Name] {
+				applied = append(applied, mesh)
+				continue
+			}
+			if d.Owner == "" {
+				d.Owner = mesh.Owner
+			}
+			d.CreatedAt = mesh.CreatedAt
+			d.UpdatedAt = now
+			applied = append(applied, d)
+		}
+
+		for _, change := range changes {
+			if change.Action != ChangeAdd {
+				continue
+			}
+			mesh := desiredByName[change.Name]
+			mesh.CreatedAt = now
+			mesh.UpdatedAt = now
+			applied = append(applied, mesh)
+		}
+		return applied, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
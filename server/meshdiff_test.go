@@ -0,0 +1,105 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffMeshes(t *testing.T) {
+	current := []Mesh{
+		{Name: "mesh-a", Env: "dev", Services: []Service{
+			{ServiceName: "redis", RemoteAddr: "redis:6379"},
+			{ServiceName: "mysql", RemoteAddr: "mysql:3306"},
+		}},
+		{Name: "mesh-b", Env: "dev", Services: []Service{{ServiceName: "api", RemoteAddr: "api:8080"}}},
+		{Name: "mesh-c", Env: "dev"},
+	}
+	desired := []Mesh{
+		{Name: "mesh-a", Env: "staging", Services: []Service{
+			{ServiceName: "redis", RemoteAddr: "redis:6380"},
+			{ServiceName: "ch", RemoteAddr: "ch:9000"},
+		}},
+		{Name: "mesh-b", Env: "dev", Services: []Service{{ServiceName: "api", RemoteAddr: "api:8080"}}},
+		{Name: "mesh-d", Env: "dev", Services: []Service{{ServiceName: "api", RemoteAddr: "api:8080"}}},
+	}
+
+	meshA := []ServiceChange{
+		{Action: ChangeUpdate, Old: &current[0].Services[0], New: &desired[0].Services[0]},
+		{Action: ChangeAdd, New: &desired[0].Services[1]},
+		{Action: ChangeRemove, Old: &current[0].Services[1]},
+	}
+	want := []MeshChange{
+		{Action: ChangeUpdate, Name: "mesh-a", Fields: []FieldChange{{Field: "env", Old: "dev", New: "staging"}}, Services: meshA},
+		{Action: ChangeAdd, Name: "mesh-d", Services: []ServiceChange{{Action: ChangeAdd, New: &desired[2].Services[0]}}},
+	}
+	if got := DiffMeshes(current, desired, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffMeshes() = %+v, want %+v", got, want)
+	}
+
+	want = append(want, MeshChange{Action: ChangeRemove, Name: "mesh-c"})
+	if got := DiffMeshes(current, desired, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffMeshes() with prune = %+v, want %+v", got, want)
+	}
+}
+
+func TestServiceMesh_ApplyMeshes(t *testing.T) {
+	sm := NewServiceMesh(NewMemoryMeshStore(
+		Mesh{Name: "mesh-a", Env: "dev", Owner: "alice"},
+		Mesh{Name: "mesh-b", Env: "dev"},
+	))
+	desired := []Mesh{
+		{Name: "mesh-a", Env: "staging"},
+		{Name: "mesh-c", Env: "dev"},
+	}
+
+	changes, err := sm.ApplyMeshes(desired, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Errorf("ServiceMesh.ApplyMeshes() got %d changes, want 3", len(changes))
+	}
+	want := []Mesh{
+		{Name: "mesh-a", Env: "staging", Owner: "alice"},
+		{Name: "mesh-c", Env: "dev"},
+	}
+	if got, _ := sm.GetAllMeshes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ServiceMesh.ApplyMeshes() = %v, want %v", got, want)
+	}
+
+	changes, err = sm.ApplyMeshes(desired, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("ServiceMesh.ApplyMeshes() again got %v, want no changes", changes)
+	}
+
+	if _, err := sm.ApplyMeshes(append(desired, desired[0]), false); err == nil {
+		t.Error("ServiceMesh.ApplyMeshes() should fail with duplicated meshes")
+	}
+}
+
+func TestDiffServices(t *testing.T) {
+	redis1 := Service{ServiceName: "redis", RemoteAddr: "redis-1:6379"}
+	redis2 := Service{ServiceName: "redis", RemoteAddr: "redis-2:6379"}
+	redis3 := Service{ServiceName: "redis", RemoteAddr: "redis-3:6379"}
+	labeled := Service{ServiceName: "redis", RemoteAddr: "redis-2:6379", Labels: map[string]string{"tier": "cache"}}
+
+	tests := []struct {
+		name             string
+		current, desired []Service
+		want             []ServiceChange
+	}{
+		{"same duplicates", []Service{redis1, redis2}, []Service{redis2, redis1}, nil},
+		{"remove a duplicate", []Service{redis1, redis2}, []Service{redis2}, []ServiceChange{{Action: ChangeRemove, Old: &redis1}}},
+		{"add a duplicate", []Service{redis1}, []Service{redis1, redis2}, []ServiceChange{{Action: ChangeAdd, New: &redis2}}},
+		{"update a duplicate", []Service{redis1, redis2}, []Service{redis1, labeled}, []ServiceChange{{Action: ChangeUpdate, Old: &redis2, New: &labeled}}},
+		{"change an address", []Service{redis1, redis2}, []Service{redis3, redis2}, []ServiceChange{{Action: ChangeUpdate, Old: &redis1, New: &redis3}}},
+	}
+	for _, tt := range tests {
+		if got := diffServices(tt.current, tt.desired); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("diffServices() %s = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
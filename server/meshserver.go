@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/goutils/lg"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MeshServer serves the mesh management api backed by ServiceMesh,
+// meshes are connected into the ServiceTunnel of the running session
+type MeshServer struct {
+	sshproxypb.UnimplementedMeshServiceServer
+
+	mesh   *ServiceMesh
+	tunnel *ServiceTunnel
+	dial   TunnelDialer
+	// protected reports whether an env is only connected with the confirm of the request
+	protected func(env string) bool
+}
+
+func NewMeshServer(mesh *ServiceMesh, tunnel *ServiceTunnel, dial TunnelDialer, protected func(env string) bool) *MeshServer {
+	return &MeshServer{
+		mesh:      mesh,
+		tunnel:    tunnel,
+		dial:      dial,
+		protected: protected,
+	}
+}
+
+// ProtectedEnvs returns the sorted envs of envServices which are protected
+func ProtectedEnvs(envServices map[string][]*sshproxypb.Service, protected func(env string) bool) []string {
+	var envs []string
+	for env := range envServices {
+		if protected != nil && protected(env) {
+			envs = append(envs, env)
+		}
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+func meshToPb(mesh *Mesh) *sshproxypb.Mesh {
+	pb := &sshproxypb.Mesh{
+		Name:        mesh.Name,
+		Env:         mesh.Env,
+		Includes:    mesh.Includes,
+		Vars:        mesh.Vars,
+		Owner:       mesh.Owner,
+		Description: mesh.Description,
+	}
+	if !mesh.CreatedAt.IsZero() {
+		pb.CreatedAt = timestamppb.New(mesh.CreatedAt)
+	}
+	if !mesh.UpdatedAt.IsZero() {
+		pb.UpdatedAt = timestamppb.New(mesh.UpdatedAt)
+	}
+	for _, service := range mesh.Services {
+		pb.Services = append(pb.Services, serviceToPb(service))
+	}
+	return pb
+}
+
+func serviceToPb(service Service) *sshproxypb.MeshServiceItem {
+	return &sshproxypb.MeshServiceItem{
+		ServiceName: service.ServiceName,
+		RemoteAddr:  service.RemoteAddr,
+		Env:         service.Env,
+		Labels:      service.Labels,
+	}
+}
+
+func meshFromPb(pb *sshproxypb.Mesh) Mesh {
+	mesh := Mesh{
+		Name:        pb.GetName(),
+		Env:         pb.GetEnv(),
+		Includes:    pb.GetIncludes(),
+		Vars:        pb.GetVars(),
+		Owner:       pb.GetOwner(),
+		Description: pb.GetDescription(),
+	}
+	for _, service := range pb.GetServices() {
+		mesh.Services = append(mesh.Services, serviceFromPb(service))
+	}
+	return mesh
+}
+
+func serviceFromPb(pb *sshproxypb.MeshServiceItem) Service {
+	service := Service{
+		ServiceName: pb.GetServiceName(),
+		RemoteAddr:  pb.GetRemoteAddr(),
+		Env:         pb.GetEnv(),
+		Labels:      pb.GetLabels(),
+	}
+	if service.ServiceName == "" {
+		service.ServiceName = service.RemoteAddr
+	}
+	return service
+}
+
+// MeshEnvServices groups the services of a rendered mesh by env for ServiceTunnel.ConnectEnvs
+func MeshEnvServices(mesh *Mesh) map[string][]*sshproxypb.Service {
+	envServices := make(map[string][]*sshproxypb.Service)
+	for env, services := range mesh.GroupServicesByEnv() {
+		for _, service := range services {
+			envServices[env] = append(envServices[env], &sshproxypb.Service{
+				ServiceName:  service.ServiceName,
+				ProxyAddress: service.RemoteAddr,
+				Labels:       service.Labels,
+			})
+		}
+	}
+	return envServices
+}
+
+func (ms *MeshServer) CreateMesh(ctx context.Context, in *sshproxypb.CreateMeshRequest) (*sshproxypb.CreateMeshResponse, error) {
+	mesh := meshFromPb(in.GetMesh())
+	if err := mesh.Validate(); err != nil {
+		return nil, err
+	}
+	// the meshes created through the session belong to the user running it
+	mesh.Owner = CurrentUser()
+	if _, err := ms.mesh.GetMesh(mesh.Name); err == nil {
+		return nil, errors.Errorf("mesh: %v already exists", mesh.Name)
+	}
+
+	if err := ms.mesh.CreateMesh(mesh); err != nil {
+		lg.Errorc(ctx, "create mesh: %v error: %v", mesh.Name, err)
+		return nil, err
+	}
+	return &sshproxypb.CreateMeshResponse{}, nil
+}
+
+func (ms *MeshServer) GetMesh(ctx context.Context, in *sshproxypb.GetMeshRequest) (*sshproxypb.GetMeshResponse, error) {
+	getMesh := ms.mesh.GetMesh
+	if in.GetExpand() {
+		getMesh = ms.mesh.ExpandMesh
+	}
+
+	mesh, err := getMesh(in.GetName())
+	if err != nil {
+		return nil, err
+	}
+	return &sshproxypb.GetMeshResponse{Mesh: meshToPb(mesh)}, nil
+}
+
+func (ms *MeshServer) ListMeshes(ctx context.Context, in *sshproxypb.ListMeshesRequest) (*sshproxypb.ListMeshesResponse, error) {
+	var meshes []Mesh
+	var err error
+	if in.GetOwner() != "" {
+		meshes, err = ms.mesh.GetUserMeshes(in.GetOwner())
+	} else {
+		meshes, err = ms.mesh.GetAllMeshes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the meshes are expanded from one load of the store, instead of a GetMesh for each
+	var allMeshes []Mesh
+	if in.GetExpand() {
+		if allMeshes, err = ms.mesh.GetAllMeshes(); err != nil {
+			return nil, err
+		}
+	}
+
+	resp := &sshproxypb.ListMeshesResponse{}
+	for i := range meshes {
+		mesh := &meshes[i]
+		if in.GetExpand() {
+			if mesh, err = expandMesh(allMeshes, mesh.Name); err != nil {
+				return nil, err
+			}
+		}
+		resp.Meshes = append(resp.Meshes, meshToPb(mesh))
+	}
+	return resp, nil
+}
+
+func (ms *MeshServer) AppendService(ctx context.Context, in *sshproxypb.AppendServiceRequest) (*sshproxypb.AppendServiceResponse, error) {
+	services := make([]*Service, 0, len(in.GetServices()))
+	for _, pb := range in.GetServices() {
+		service := serviceFromPb(pb)
+		if err := validateServiceAddr(service.RemoteAddr); err != nil {
+			return nil, errors.Wrapf(err, "mesh %s service %s", in.GetMeshName(), service.ServiceName)
+		}
+		services = append(services, &service)
+	}
+
+	if err := ms.mesh.AddServiceToMesh(in.GetMeshName(), services...); err != nil {
+		return nil, err
+	}
+	return &sshproxypb.AppendServiceResponse{}, nil
+}
+
+func (ms *MeshServer) RemoveService(ctx context.Context, in *sshproxypb.RemoveServiceRequest) (*sshproxypb.RemoveServiceResponse, error) {
+	for _, service := range in.GetServices() {
+		if err := ms.mesh.RemoveServiceFromMesh(in.GetMeshName(), service); err != nil {
+			return nil, err
+		}
+	}
+	return &sshproxypb.RemoveServiceResponse{}, nil
+}
+
+func (ms *MeshServer) DeleteMesh(ctx context.Context, in *sshproxypb.DeleteMeshRequest) (*sshproxypb.DeleteMeshResponse, error) {
+	if err := ms.mesh.DeleteMesh(in.GetName()); err != nil {
+		return nil, err
+	}
+	return &sshproxypb.DeleteMeshResponse{}, nil
+}
+
+func (ms *MeshServer) ConnectMesh(ctx context.Context, in *sshproxypb.ConnectMeshRequest) (*sshproxypb.ConnectMeshResponse, error) {
+	var mesh *Mesh
+	if in.GetMesh() != nil {
+		m := meshFromPb(in.GetMesh())
+		if err := m.Validate(); err != nil {
+			return nil, err
+		}
+		mesh = &m
+	} else {
+		var err error
+		if mesh, err = ms.mesh.ExpandMesh(in.GetName()); err != nil {
+			return nil, err
+		}
+	}
+	mesh, err := mesh.Render(in.GetVars())
+	if err != nil {
+		return nil, err
+	}
+	mesh = mesh.Filter(nil, in.GetServices(), nil)
+
+	envServices := MeshEnvServices(mesh)
+	// each request confirms the protected envs it connects, not the session
+	if envs := ProtectedEnvs(envServices, ms.protected); len(envs) > 0 && !in.GetConfirm() {
+		return nil, status.Errorf(codes.FailedPrecondition, "envs %s are protected, set confirm to connect them", strings.Join(envs, ", "))
+	}
+
+	nodes, _, err := ms.tunnel.ConnectEnvs(ctx, envServices, ms.dial)
+	if err != nil {
+		lg.Errorc(ctx, "connect mesh: %v error: %v", mesh.Name, err)
+		return nil, err
+	}
+	lg.Infoc(ctx, "connect mesh: %v success", mesh.Name)
+
+	return &sshproxypb.ConnectMeshResponse{ConnectedNodes: nodes}, nil
+}
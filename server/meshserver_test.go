@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+	"github.com/superwhys/sshtunnel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMeshServer(t *testing.T) {
+	ctx := context.Background()
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	ms := NewMeshServer(NewServiceMesh(NewMemoryMeshStore()), NewServiceTunnel(), func(env string) (*sshtunnel.SshTunnel, error) {
+		return nil, errors.New("no ssh server in test")
+	}, nil)
+
+	// the owner is the user running the server, not the one in the request
+	mesh := &sshproxypb.Mesh{Name: "mesh-1", Env: "env-1", Owner: "someone-else", Services: []*sshproxypb.MeshServiceItem{
+		{ServiceName: "service-1", RemoteAddr: "remote-addr-1:80"},
+	}}
+	if _, err := ms.CreateMesh(ctx, &sshproxypb.CreateMeshRequest{Mesh: mesh}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.CreateMesh(ctx, &sshproxypb.CreateMeshRequest{Mesh: mesh}); err == nil {
+		t.Error("MeshServer.CreateMesh() should fail on existing mesh")
+	}
+	if _, err := ms.AppendService(ctx, &sshproxypb.AppendServiceRequest{MeshName: "mesh-1", Services: []*sshproxypb.MeshServiceItem{{RemoteAddr: "invalid"}}}); err == nil {
+		t.Error("MeshServer.AppendService() should fail on invalid address")
+	}
+	if _, err := ms.AppendService(ctx, &sshproxypb.AppendServiceRequest{MeshName: "mesh-1", Services: []*sshproxypb.MeshServiceItem{
+		{RemoteAddr: "remote-addr-2:80", Env: "env-2"},
+		{RemoteAddr: "remote-addr-2:80", Env: "env-2"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.RemoveService(ctx, &sshproxypb.RemoveServiceRequest{MeshName: "mesh-1", Services: []string{"service-1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ms.GetMesh(ctx, &sshproxypb.GetMeshRequest{Name: "mesh-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &sshproxypb.Mesh{Name: "mesh-1", Env: "env-1", Owner: CurrentUser(), Services: []*sshproxypb.MeshServiceItem{
+		{ServiceName: "remote-addr-2:80", RemoteAddr: "remote-addr-2:80", Env: "env-2"},
+	}}
+	if !proto.Equal(got.GetMesh(), want) {
+		t.Errorf("MeshServer.GetMesh() = %v, want %v", got.GetMesh(), want)
+	}
+
+	if _, err := ms.ConnectMesh(ctx, &sshproxypb.ConnectMeshRequest{Name: "mesh-1"}); err == nil {
+		t.Error("MeshServer.ConnectMesh() should fail if the tunnel can not be dialed")
+	}
+
+	if _, err := ms.DeleteMesh(ctx, &sshproxypb.DeleteMeshRequest{Name: "mesh-1"}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := ms.ListMeshes(ctx, &sshproxypb.ListMeshesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.GetMeshes(), []*sshproxypb.Mesh(nil)) {
+		t.Errorf("MeshServer.ListMeshes() = %v, want empty", list.GetMeshes())
+	}
+}
+
+func TestMeshServer_Protected(t *testing.T) {
+	ctx := context.Background()
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	sshAddr, identityFile := startSSHServer(t)
+	echoAddr := startEchoServer(t)
+
+	var dials []string
+	st := NewServiceTunnel()
+	defer st.Close()
+	ms := NewMeshServer(NewServiceMesh(NewMemoryMeshStore()), st, func(env string) (*sshtunnel.SshTunnel, error) {
+		dials = append(dials, env)
+		return sshtunnel.NewTunnel(&sshtunnel.SshConfig{HostName: sshAddr, IdentityFile: identityFile}), nil
+	}, func(env string) bool {
+		return env == "prod"
+	})
+
+	mesh := &sshproxypb.Mesh{Name: "mesh-1", Env: "dev", Services: []*sshproxypb.MeshServiceItem{
+		{ServiceName: "echo", RemoteAddr: echoAddr},
+		{ServiceName: "echo-prod", RemoteAddr: echoAddr, Env: "prod"},
+	}}
+	if _, err := ms.CreateMesh(ctx, &sshproxypb.CreateMeshRequest{Mesh: mesh}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		req   *sshproxypb.ConnectMeshRequest
+		code  codes.Code
+		dials int
+	}{
+		{&sshproxypb.ConnectMeshRequest{Name: "mesh-1"}, codes.FailedPrecondition, 0},
+		{&sshproxypb.ConnectMeshRequest{Name: "mesh-1", Services: []string{"echo"}}, codes.OK, 1},
+		// a confirm of an earlier request does not apply to the later ones
+		{&sshproxypb.ConnectMeshRequest{Name: "mesh-1", Services: []string{"echo-prod"}, Confirm: true}, codes.OK, 2},
+		{&sshproxypb.ConnectMeshRequest{Mesh: &sshproxypb.Mesh{Name: "ui-prod", Env: "prod", Services: mesh.Services[:1]}}, codes.FailedPrecondition, 2},
+	}
+	for _, tt := range tests {
+		_, err := ms.ConnectMesh(ctx, tt.req)
+		if status.Code(err) != tt.code {
+			t.Errorf("MeshServer.ConnectMesh(%v) error = %v, want code %v", tt.req, err, tt.code)
+		}
+		if len(dials) != tt.dials {
+			t.Errorf("MeshServer.ConnectMesh(%v) dialed %v, want %d dials", tt.req, dials, tt.dials)
+		}
+	}
+}
+
+func TestMeshServer_ListMeshesExpand(t *testing.T) {
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	ms := NewMeshServer(NewServiceMesh(NewMemoryMeshStore(
+		Mesh{Name: "base", Env: "dev", Services: []Service{{ServiceName: "redis", RemoteAddr: "redis:6379"}}},
+		Mesh{Name: "app", Env: "dev", Includes: []string{"base"}, Services: []Service{{ServiceName: "web", RemoteAddr: "web:80"}}},
+	)), NewServiceTunnel(), nil, nil)
+
+	tests := []struct {
+		expand bool
+		want   map[string]int
+	}{
+		{false, map[string]int{"base": 1, "app": 1}},
+		{true, map[string]int{"base": 1, "app": 2}},
+	}
+	for _, tt := range tests {
+		resp, err := ms.ListMeshes(context.Background(), &sshproxypb.ListMeshesRequest{Expand: tt.expand})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]int)
+		for _, m := range resp.GetMeshes() {
+			got[m.GetName()] = len(m.GetServices())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListMeshes(expand=%v) services = %v, want %v", tt.expand, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	MeshStoreFile   = "file"
+	MeshStoreBolt   = "bolt"
+	MeshStoreMemory = "memory"
+)
+
+var (
+	// errMeshUnchanged is returned by an update function
+	// when there is nothing to write back
+	errMeshUnchanged = errors.New("mesh unchanged")
+)
+
+// MeshStore is the storage backend of ServiceMesh
+type MeshStore interface {
+	// Load returns all the meshes in the store
+	Load() ([]Mesh, error)
+	// Update runs a read-modify-write cycle on all the meshes,
+	// fn must not keep a reference to the given slice
+	Update(fn func(meshes []Mesh) ([]Mesh, error)) error
+}
+
+// NewMeshStore creates the store of the given kind,
+// an empty path means the default location of that kind in the state dir
+func NewMeshStore(kind, path string) (MeshStore, error) {
+	if path == "" && kind != MeshStoreMemory {
+		if err := os.MkdirAll(StateDir(), stateDirPerm); err != nil {
+			return nil, errors.Wrap(err, "create state dir")
+		}
+	}
+
+	switch kind {
+	case "", MeshStoreFile:
+		if path == "" {
+			path = statePath(meshFile)
+		}
+		return NewFileMeshStore(path), nil
+	case MeshStoreBolt:
+		if path == "" {
+			path = statePath(meshBoltDB)
+		}
+		return NewBoltMeshStore(path), nil
+	case MeshStoreMemory:
+		return NewMemoryMeshStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown mesh store: %v", kind)
+	}
+}
+
+// MemoryMeshStore keeps meshes in memory, it is mainly used in tests
+type MemoryMeshStore struct {
+	lock   sync.Mutex
+	meshes []Mesh
+}
+
+func NewMemoryMeshStore(meshes ...Mesh) *MemoryMeshStore {
+	return &MemoryMeshStore{meshes: copyMeshes(meshes)}
+}
+
+func (ms *MemoryMeshStore) Load() ([]Mesh, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	return copyMeshes(ms.meshes), nil
+}
+
+func (ms *MemoryMeshStore) Update(fn func(meshes []Mesh) ([]Mesh, error)) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	meshes, err := fn(copyMeshes(ms.meshes))
+	if err == errMeshUnchanged {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ms.meshes = copyMeshes(meshes)
+	return nil
+}
+
+func copyMeshes(meshes []Mesh) []Mesh {
+	if meshes == nil {
+		return nil
+	}
+
+	cp := make([]Mesh, len(meshes))
+	for i, m := range meshes {
+		cp[i] = m
+		if m.Services != nil {
+			cp[i].Services = make([]Service, len(m.Services))
+			for j, service := range m.Services {
+				cp[i].Services[j] = service
+				cp[i].Services[j].Labels = copyStringMap(service.Labels)
+			}
+		}
+		if m.Includes != nil {
+			cp[i].Includes = append([]string{}, m.Includes...)
+		}
+		cp[i].Vars = copyStringMap(m.Vars)
+	}
+	return cp
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(m))
+	for k, v := range m {
+		cp[k] = v
+	}
+	return cp
+}
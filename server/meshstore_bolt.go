@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	meshBucket = []byte("meshes")
+)
+
+// BoltMeshStore stores each mesh as a key in an embedded bolt db,
+// it suits stores with a large amount of meshes.
+// Meshes are loaded in the order of their names.
+type BoltMeshStore struct {
+	path string
+}
+
+func NewBoltMeshStore(path string) *BoltMeshStore {
+	return &BoltMeshStore{path: path}
+}
+
+// open opens the db for a single operation, so that multiple
+// processes can share the same db file
+func (bs *BoltMeshStore) open() (*bolt.DB, error) {
+	db, err := bolt.Open(bs.path, meshFilePerm, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "open mesh db error")
+	}
+
+	return db, nil
+}
+
+func (bs *BoltMeshStore) loadBucket(b *bolt.Bucket) ([]Mesh, error) {
+	if b == nil {
+		return nil, nil
+	}
+
+	var meshes []Mesh
+	err := b.ForEach(func(k, v []byte) error {
+		var mesh Mesh
+		if err := json.Unmarshal(v, &mesh); err != nil {
+			return errors.Wrapf(err, "parse mesh %s error", k)
+		}
+		meshes = append(meshes, mesh)
+		return nil
+	})
+	return meshes, err
+}
+
+func (bs *BoltMeshStore) Load() ([]Mesh, error) {
+	db, err := bs.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var meshes []Mesh
+	err = db.View(func(tx *bolt.Tx) error {
+		meshes, err = bs.loadBucket(tx.Bucket(meshBucket))
+		return err
+	})
+	return meshes, err
+}
+
+// Update runs fn in a single bolt transaction and only writes back the changed meshes
+func (bs *BoltMeshStore) Update(fn func(meshes []Mesh) ([]Mesh, error)) error {
+	db, err := bs.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(meshBucket)
+		if err != nil {
+			return errors.Wrap(err, "create mesh bucket error")
+		}
+
+		meshes, err := bs.loadBucket(b)
+		if err != nil {
+			return err
+		}
+		meshes, err = fn(meshes)
+		if err != nil {
+			return err
+		}
+
+		keep := make(map[string]bool, len(meshes))
+		for _, mesh := range meshes {
+			keep[mesh.Name] = true
+
+			v, err := json.Marshal(mesh)
+			if err != nil {
+				return errors.Wrap(err, "marshal mesh error")
+			}
+			if bytes.Equal(b.Get([]byte(mesh.Name)), v) {
+				continue
+			}
+			if err := b.Put([]byte(mesh.Name), v); err != nil {
+				return errors.Wrapf(err, "put mesh %s error", mesh.Name)
+			}
+		}
+
+		var deleted [][]byte
+		err = b.ForEach(func(k, _ []byte) error {
+			if !keep[string(k)] {
+				deleted = append(deleted, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range deleted {
+			if err := b.Delete(k); err != nil {
+				return errors.Wrapf(err, "delete mesh %s error", k)
+			}
+		}
+
+		return nil
+	})
+	if err == errMeshUnchanged {
+		return nil
+	}
+
+	return err
+}
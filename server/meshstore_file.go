@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+)
+
+var (
+	// the mesh file may contain internal hostnames, keep it private
+	meshFilePerm os.FileMode = 0600
+)
+
+// FileMeshStore stores all meshes in a single json file
+type FileMeshStore struct {
+	path string
+}
+
+func NewFileMeshStore(path string) *FileMeshStore {
+	return &FileMeshStore{path: path}
+}
+
+// lock takes an advisory lock on a sidecar lock file,
+// so that the mesh file itself can be replaced by rename
+func (fs *FileMeshStore) lock(exclusive bool) (*flock.Flock, error) {
+	lock := flock.New(fs.path + ".lock")
+
+	var err error
+	if exclusive {
+		err = lock.Lock()
+	} else {
+		err = lock.RLock()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "lock mesh file error")
+	}
+
+	return lock, nil
+}
+
+func (fs *FileMeshStore) readMeshFile() ([]byte, error) {
+	b, err := os.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read file error")
+	}
+
+	return b, nil
+}
+
+func (fs *FileMeshStore) writeMeshFile(origin, data []byte) error {
+	if len(origin) > 0 {
+		if err := WriteFileAtomic(fs.path+".bak", origin, meshFilePerm); err != nil {
+			return errors.Wrap(err, "backup mesh file error")
+		}
+	}
+
+	return WriteFileAtomic(fs.path, data, meshFilePerm)
+}
+
+// meshFileVersion is the format version of the mesh file
+const meshFileVersion = 1
+
+type meshFileData struct {
+	Version int    `json:"version"`
+	Meshes  []Mesh `json:"meshes"`
+}
+
+// parseMeshFile parses the versioned mesh file,
+// the legacy file of a bare mesh array is also supported
+func parseMeshFile(b []byte) ([]Mesh, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	if b[0] == '[' {
+		var meshes []Mesh
+		if err := json.Unmarshal(b, &meshes); err != nil {
+			return nil, errors.Wrap(err, "parse meshes error")
+		}
+		return meshes, nil
+	}
+
+	var data meshFileData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, errors.Wrap(err, "parse meshes error")
+	}
+	if data.Version > meshFileVersion {
+		return nil, fmt.Errorf("mesh file version %d is newer than %d supported, please upgrade ssh-proxy", data.Version, meshFileVersion)
+	}
+	return data.Meshes, nil
+}
+
+func marshalMeshFile(meshes []Mesh) ([]byte, error) {
+	b, err := json.Marshal(meshFileData{Version: meshFileVersion, Meshes: meshes})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal meshes error")
+	}
+	return b, nil
+}
+
+func (fs *FileMeshStore) Load() ([]Mesh, error) {
+	lock, err := fs.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	b, err := fs.readMeshFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "get all meshes error")
+	}
+	return parseMeshFile(b)
+}
+
+// Update runs fn while holding an exclusive lock on the mesh file
+func (fs *FileMeshStore) Update(fn func(meshes []Mesh) ([]Mesh, error)) error {
+	lock, err := fs.lock(true)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	origin, err := fs.readMeshFile()
+	if err != nil {
+		return errors.Wrap(err, "get all meshes error")
+	}
+	meshes, err := parseMeshFile(origin)
+	if err != nil {
+		return errors.Wrap(err, "parse meshes error")
+	}
+
+	meshes, err = fn(meshes)
+	if err == errMeshUnchanged {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	b, err := marshalMeshFile(meshes)
+	if err != nil {
+		return err
+	}
+
+	return fs.writeMeshFile(origin, b)
+}
+
+// WriteFileAtomic writes data to a temp file in the same dir
+// and renames it to path, so readers never see a partial file
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "create temp file error")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "chmod temp file error")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write file error")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "sync file error")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "close file error")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), path), "rename file error")
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func testMeshStores(t *testing.T) map[string]MeshStore {
+	dir := t.TempDir()
+	return map[string]MeshStore{
+		MeshStoreFile:   NewFileMeshStore(filepath.Join(dir, "mesh.json")),
+		MeshStoreBolt:   NewBoltMeshStore(filepath.Join(dir, "mesh.db")),
+		MeshStoreMemory: NewMemoryMeshStore(),
+	}
+}
+
+func TestMeshStore_Update(t *testing.T) {
+	for kind, store := range testMeshStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			s := NewServiceMesh(store)
+			if err := s.CreateMesh(Mesh{Name: "mesh-b", Env: "env-1", Services: []Service{{ServiceName: "service-1", RemoteAddr: "remote-addr-1"}}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.CreateMesh(Mesh{Name: "mesh-a", Env: "env-2"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AddServiceToMesh("mesh-a", &Service{ServiceName: "service-2", RemoteAddr: "remote-addr-2"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AddServiceToMesh("mesh-c", &Service{ServiceName: "service-2", RemoteAddr: "remote-addr-2"}); err == nil {
+				t.Error("ServiceMesh.AddServiceToMesh() to a not exists mesh should fail")
+			}
+			if err := s.DeleteMesh("mesh-b"); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := s.GetAllMeshes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []Mesh{{Name: "mesh-a", Env: "env-2", Services: []Service{{ServiceName: "service-2", RemoteAddr: "remote-addr-2"}}}}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ServiceMesh.GetAllMeshes() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMeshStore_FailedUpdate(t *testing.T) {
+	for kind, store := range testMeshStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			newMeshes := func() []Mesh {
+				return []Mesh{{Name: "mesh-a", Env: "env-1", Includes: []string{"mesh-b"}, Vars: map[string]string{"shard": "1"}, Services: []Service{
+					{ServiceName: "service-1", RemoteAddr: "remote-addr-1", Labels: map[string]string{"tier": "db"}},
+				}}}
+			}
+			if err := store.Update(func([]Mesh) ([]Mesh, error) { return newMeshes(), nil }); err != nil {
+				t.Fatal(err)
+			}
+
+			// the changes of a failed update are not kept
+			store.Update(func(meshes []Mesh) ([]Mesh, error) {
+				meshes[0].Includes[0] = "mesh-c"
+				meshes[0].Vars["shard"] = "2"
+				meshes[0].Services[0].Labels["tier"] = "cache"
+				return nil, fmt.Errorf("failed")
+			})
+
+			got, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := newMeshes(); !reflect.DeepEqual(got, want) {
+				t.Errorf("MeshStore.Load() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMeshStore_ConcurrentUpdate(t *testing.T) {
+	for kind, store := range testMeshStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			s := NewServiceMesh(store)
+			if err := s.CreateMesh(Mesh{Name: "mesh-concurrent", Env: "env-1"}); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					addr := fmt.Sprintf("remote-addr-%d", i)
+					if err := s.AddServiceToMesh("mesh-concurrent", &Service{ServiceName: addr, RemoteAddr: addr}); err != nil {
+						t.Error(err)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			got, err := s.GetMesh("mesh-concurrent")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Services) != 20 {
+				t.Errorf("ServiceMesh.AddServiceToMesh() lost updates, got %d services, want 20", len(got.Services))
+			}
+		})
+	}
+}
+
+func TestFileMeshStore_Perm(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mesh.json")
+	if err := os.WriteFile(path, []byte("[]"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServiceMesh(NewFileMeshStore(path))
+	if err := s.CreateMesh(Mesh{Name: "mesh-1", Env: "env-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{path, path + ".bak"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != meshFilePerm {
+			t.Errorf("%s perm = %v, want %v", p, perm, meshFilePerm)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+)
+
+// NodeReady checks the local listener of the node accepts connections
+func NodeReady(node *sshproxypb.Node, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", NodeLocalAddr(node), timeout)
+	if err != nil {
+		return errors.Wrapf(err, "dial %s", node.GetServiceName())
+	}
+	return conn.Close()
+}
+
+// PendingServices returns the services which are not connected or not ready yet,
+// names are matched by the service name, case-insensitively, or the remote address of the nodes,
+// all the nodes are checked if no names given, and no nodes are pending as the services are not connected yet
+func PendingServices(nodes []*sshproxypb.Node, names []string, timeout time.Duration) map[string]error {
+	pending := make(map[string]error)
+
+	if len(names) == 0 {
+		if len(nodes) == 0 {
+			pending["services"] = errors.New("not connected")
+		}
+		for _, node := range nodes {
+			if err := NodeReady(node, timeout); err != nil {
+				pending[node.GetServiceName()] = err
+			}
+		}
+		return pending
+	}
+
+	for _, name := range names {
+		pending[name] = errors.New("not connected")
+		for _, node := range nodes {
+			if !strings.EqualFold(node.GetServiceName(), name) && node.GetRemoteAddress() != name {
+				continue
+			}
+			if err := NodeReady(node, timeout); err != nil {
+				pending[name] = err
+			} else {
+				delete(pending, name)
+				break
+			}
+		}
+	}
+	return pending
+}
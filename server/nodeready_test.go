@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+)
+
+func TestPendingServices(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	nodes := []*sshproxypb.Node{
+		{ServiceName: "redis", RemoteAddress: "redis:6379", LocalAddress: l.Addr().String()},
+		{ServiceName: "mysql", RemoteAddress: "mysql:3306", LocalAddress: closed.Addr().String()},
+	}
+
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{nil, []string{"mysql"}},
+		{[]string{"redis"}, nil},
+		{[]string{"redis:6379", "mysql", "api"}, []string{"api", "mysql"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for name := range PendingServices(nodes, tt.names, time.Second) {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("PendingServices(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+
+	if pending := PendingServices(nil, nil, time.Second); len(pending) == 0 {
+		t.Error("PendingServices() of no nodes is ready, want pending")
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+)
+
+// portCacheVersion is the format version of the port cache file
+const portCacheVersion = 1
+
+// portCacheData maps the remote addr to the local port it was forwarded to
+type portCacheData struct {
+	Version int               `json:"version"`
+	Ports   map[string]string `json:"ports"`
+}
+
+// parseLegacyPortCache parses the "remoteAddr-localPort" lines of the legacy cache,
+// the remote host may contain "-" as well, e.g. api.dev-42.internal:8080-35001
+func parseLegacyPortCache(b []byte) map[string]string {
+	ports := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		idx := strings.LastIndex(line, "-")
+		if idx <= 0 || idx == len(line)-1 {
+			continue
+		}
+		ports[line[:idx]] = line[idx+1:]
+	}
+	return ports
+}
+
+// portCacheKeySep separates the scope and the address in the keys of the port cache,
+// it can not appear in the address, whose host is a hostname or an ip
+const portCacheKeySep = "|"
+
+// portCacheKey is the key of the local port of proxyAddr reached through scope, which is an env
+// or the ssh host of direct mode, so the same address of dev and staging, e.g. redis:6379, has its own port.
+// The legacy cache is keyed by proxyAddr only
+func portCacheKey(scope, proxyAddr string) string {
+	return scope + portCacheKeySep + proxyAddr
+}
+
+// SplitPortCacheKey splits a key of LocalPorts into the env or ssh host and the remote address,
+// the scope is empty for the keys cached by older versions
+func SplitPortCacheKey(key string) (string, string) {
+	idx := strings.LastIndex(key, portCacheKeySep)
+	if idx < 0 {
+		return "", key
+	}
+	return key[:idx], key[idx+len(portCacheKeySep):]
+}
+
+func parsePortCache(b []byte) (map[string]string, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return make(map[string]string), nil
+	}
+
+	var data portCacheData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, errors.Wrap(err, "parse port cache error")
+	}
+	if data.Version > portCacheVersion {
+		return nil, fmt.Errorf("port cache version %d is newer than %d supported, please upgrade ssh-proxy", data.Version, portCacheVersion)
+	}
+	if data.Ports == nil {
+		data.Ports = make(map[string]string)
+	}
+	return data.Ports, nil
+}
+
+func marshalPortCache(ports map[string]string) ([]byte, error) {
+	b, err := json.Marshal(portCacheData{Version: portCacheVersion, Ports: ports})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal port cache error")
+	}
+	return b, nil
+}
+
+func loadPortCache(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read port cache error")
+	}
+	return parsePortCache(b)
+}
+
+// LocalPorts returns the cached local ports of the remote addrs,
+// which are reused when the services are connected again
+func LocalPorts() (map[string]string, error) {
+	return loadPortCache(localPortCacheFile)
+}
+
+// savePortCache adds the port to the cache file, the file is reloaded
+// under the lock so the ports saved by other sessions are kept
+func savePortCache(path, remoteAddr, localPort string) error {
+	if err := os.MkdirAll(filepath.Dir(path), stateDirPerm); err != nil {
+		return errors.Wrap(err, "create port cache dir error")
+	}
+	lock := flock.New(path + ".lock")
+	if err := lock.Lock(); err != nil {
+		return errors.Wrap(err, "lock port cache error")
+	}
+	defer lock.Unlock()
+
+	ports, err := loadPortCache(path)
+	if err != nil {
+		return err
+	}
+	ports[remoteAddr] = localPort
+
+	b, err := marshalPortCache(ports)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, b, meshFilePerm)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/superwhys/goutils/lg"
+)
+
+// nodeStats counts the connections forwarded through a node
+type nodeStats struct {
+	active   atomic.Int64
+	total    atomic.Int64
+	sent     atomic.Int64
+	received atomic.Int64
+	lastErr  atomic.Value
+}
+
+func (s *nodeStats) setErr(err error) {
+	if err == nil {
+		s.lastErr.Store("")
+		return
+	}
+	s.lastErr.Store(err.Error())
+}
+
+func (s *nodeStats) lastError() string {
+	err, _ := s.lastErr.Load().(string)
+	return err
+}
+
+// countingWriter adds the bytes written to n
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+// relay accepts the connections of the node on l and pipes them to the
+// tunnel forwarding on tunnelAddr, so they are counted in stats
+func relay(ctx context.Context, l net.Listener, tunnelAddr string, stats *nodeStats) {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				lg.Errorf("relay %s accept error: %v", l.Addr(), err)
+			}
+			return
+		}
+		go relayConn(client, tunnelAddr, stats)
+	}
+}
+
+// wakeForward unblocks the Accept of the tunnel forwarding on tunnelAddr, whose ctx is done.
+// sshtunnel only checks the ctx after an Accept returns, so the listener and the goroutine
+// of the forward would be kept until the next connection otherwise
+func wakeForward(tunnelAddr string) {
+	conn, err := net.DialTimeout("tcp", tunnelAddr, time.Second)
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
+
+func relayConn(client net.Conn, tunnelAddr string, stats *nodeStats) {
+	defer client.Close()
+	stats.total.Add(1)
+	stats.active.Add(1)
+	defer stats.active.Add(-1)
+
+	remote, err := net.Dial("tcp", tunnelAddr)
+	if err != nil {
+		stats.setErr(err)
+		return
+	}
+	defer remote.Close()
+	stats.setErr(nil)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(countingWriter{remote, &stats.sent}, client)
+		// stop the other direction once a side is closed
+		remote.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(countingWriter{client, &stats.received}, remote)
+		client.Close()
+	}()
+	wg.Wait()
+}
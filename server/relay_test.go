@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+	"github.com/superwhys/sshtunnel"
+)
+
+func TestRelay(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stats := &nodeStats{}
+	go relay(ctx, l, echo.Addr().String(), stats)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for stats.active.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := [4]int64{stats.active.Load(), stats.total.Load(), stats.sent.Load(), stats.received.Load()}; got != [4]int64{0, 1, 5, 5} {
+		t.Errorf("relay stats (active, total, sent, received) = %v, want [0 1 5 5]", got)
+	}
+
+	echo.Close()
+	conn, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Read(buf)
+	conn.Close()
+	if stats.lastError() == "" {
+		t.Error("relay should record the error of dialing the closed tunnel")
+	}
+}
+
+func TestDisconnect_ReleasesForward(t *testing.T) {
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	sshAddr, identityFile := startSSHServer(t)
+	echoAddr := startEchoServer(t)
+
+	st := NewServiceTunnel()
+	defer st.Close()
+	tunnel := sshtunnel.NewTunnel(&sshtunnel.SshConfig{HostName: sshAddr, IdentityFile: identityFile})
+	nodes, _, err := st.ConnectEnvs(context.Background(), map[string][]*sshproxypb.Service{
+		"dev": {{ServiceName: "echo", ProxyAddress: echoAddr, LocalHost: "127.0.0.1"}},
+	}, func(env string) (*sshtunnel.SshTunnel, error) {
+		return tunnel, nil
+	})
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("ConnectEnvs() = %v, %v", nodes, err)
+	}
+	echoThrough(t, NodeLocalAddr(nodes[0]))
+
+	if _, err := st.Disconnect(context.Background(), &sshproxypb.DisconnectRequest{
+		HostAddress:  nodes[0].GetHostAddress(),
+		ProxyAddress: echoAddr,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the forwards of the tunnel are done once their listeners are closed
+	done := make(chan struct{})
+	go func() {
+		tunnel.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Error("the forward of the disconnected node is still listening")
+	}
+}
@@ -3,10 +3,10 @@ package server
 import (
 	"context"
 	"fmt"
-	"io"
 	"net"
-	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/superwhys/goutils/lg"
@@ -15,7 +15,7 @@ import (
 )
 
 var (
-	localPortCacheFile = "/tmp/.service-tunnel-local-port-cache"
+	localPortCacheFile = statePath(portCacheFile)
 )
 
 type portCache struct {
@@ -30,18 +30,57 @@ type ServiceTunnel struct {
 	// the key is a string which combine with hostAddr and proxyAddr
 	// e.g: ${hostAddr}_${proxyAddr}
 	serviceLocalPortCache     map[string]*portCache
-	serviceLocalPortCacheFile *os.File
+	serviceLocalPortCacheFile string
+	// portsMu guards serviceLocalPortCache and its file,
+	// which are used by dialService outside of mu
+	portsMu sync.Mutex
+	// mu guards tunnels, connectedMaps and envHosts,
+	// which are used by the grpc requests concurrently
+	mu sync.Mutex
 	// cache each hostAddr tunnel
 	// the key is hostAddr
 	tunnels map[string]*sshtunnel.SshTunnel
 	// use to cache the connected node in each host
 	// the key is hostAddr
 	connectedMaps map[string][]*connectedNode
+	// the hostAddr of the tunnel dialed for each env
+	envHosts map[string]string
+	// envDials serializes the dial of the tunnel of each env,
+	// so the concurrent requests of an env dial it once
+	envDials map[string]*sync.Mutex
+	// directDials serializes the dial of the tunnels of direct mode
+	directDials sync.Mutex
+	// called with all the connected nodes whenever they change
+	onNodesChange func(nodes []*sshproxypb.Node)
 }
 
+// TunnelDialer dials the tunnel of an env
+type TunnelDialer func(env string) (*sshtunnel.SshTunnel, error)
+
 type connectedNode struct {
 	Node   *sshproxypb.Node
+	Labels map[string]string
 	Cancel context.CancelFunc
+	stats  *nodeStats
+}
+
+// withStats returns a copy of the node with the counters of its connections
+func (cn *connectedNode) withStats() *sshproxypb.Node {
+	node := &sshproxypb.Node{
+		LocalAddress:  cn.Node.GetLocalAddress(),
+		RemoteAddress: cn.Node.GetRemoteAddress(),
+		HostAddress:   cn.Node.GetHostAddress(),
+		ServiceName:   cn.Node.GetServiceName(),
+		Tag:           cn.Node.GetTag(),
+	}
+	if cn.stats != nil {
+		node.ActiveConnections = cn.stats.active.Load()
+		node.TotalConnections = cn.stats.total.Load()
+		node.BytesSent = cn.stats.sent.Load()
+		node.BytesReceived = cn.stats.received.Load()
+		node.LastError = cn.stats.lastError()
+	}
+	return node
 }
 
 func randomLocalAddr() string {
@@ -54,34 +93,68 @@ func randomLocalAddr() string {
 	return l.Addr().String()
 }
 
+// tunnelBindAttempts is the number of loopback ports tried by buildTunnel
+const tunnelBindAttempts = 3
+
+// loopbackAddr returns a free port on the loopback address
+func loopbackAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", errors.Wrap(err, "listen on loopback")
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
 func NewServiceTunnel() *ServiceTunnel {
-	file, err := os.OpenFile(localPortCacheFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
-	lg.PanicError(err)
-	data, err := io.ReadAll(file)
-	lg.PanicError(err)
+	ports, err := loadPortCache(localPortCacheFile)
+	if err != nil {
+		lg.Warnf("load local port cache: %v", err)
+	}
 
 	cache := make(map[string]*portCache)
-	for _, line := range strings.Split(string(data), "\n") {
-		lineSplit := strings.Split(line, "-")
-		if len(lineSplit) != 2 {
-			continue
-		}
-
-		cache[lineSplit[0]] = &portCache{
-			RemoteAddr: lineSplit[0],
-			LocalPort:  lineSplit[1],
+	for remoteAddr, localPort := range ports {
+		cache[remoteAddr] = &portCache{
+			RemoteAddr: remoteAddr,
+			LocalPort:  localPort,
 		}
 	}
 
 	return &ServiceTunnel{
 		serviceLocalPortCache:     cache,
-		serviceLocalPortCacheFile: file,
+		serviceLocalPortCacheFile: localPortCacheFile,
 		tunnels:                   make(map[string]*sshtunnel.SshTunnel),
 		connectedMaps:             make(map[string][]*connectedNode),
+		envHosts:                  make(map[string]string),
+		envDials:                  make(map[string]*sync.Mutex),
+	}
+}
+
+// OnNodesChange registers fn to be called with all the connected nodes
+// after services are connected or disconnected
+func (st *ServiceTunnel) OnNodesChange(fn func(nodes []*sshproxypb.Node)) {
+	st.onNodesChange = fn
+}
+
+// notifyNodesChange must be called with st.mu held
+func (st *ServiceTunnel) notifyNodesChange() {
+	if st.onNodesChange == nil {
+		return
+	}
+
+	var nodes []*sshproxypb.Node
+	for _, connectedNodes := range st.connectedMaps {
+		for _, n := range connectedNodes {
+			nodes = append(nodes, n.Node)
+		}
 	}
+	st.onNodesChange(nodes)
 }
 
 func (st *ServiceTunnel) DialTunnel(tunnel *sshtunnel.SshTunnel) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
 	_, exists := st.tunnels[tunnel.GetRemoteHost()]
 	if exists {
 		return nil
@@ -90,7 +163,154 @@ func (st *ServiceTunnel) DialTunnel(tunnel *sshtunnel.SshTunnel) error {
 	return nil
 }
 
+// DirectDialer dials the tunnel of a ssh host of direct mode, user is empty if not given
+type DirectDialer func(user, host string) (*sshtunnel.SshTunnel, error)
+
+// ConnectDirect connects the services whose RemoteAddress is the [user@]host:port of their ssh host,
+// the tunnels are keyed by the RemoteAddress, so the same host with different users has its own tunnel
+func (st *ServiceTunnel) ConnectDirect(ctx context.Context, services []*sshproxypb.Service, dial DirectDialer) ([]*sshproxypb.Node, error) {
+	if err := st.dialDirect(services, dial); err != nil {
+		return nil, err
+	}
+
+	resp, err := st.Connect(ctx, &sshproxypb.ConnectRequest{
+		Services: services,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetConnectedNodes(), nil
+}
+
+// dialDirect dials the tunnels of the services which have not been dialed
+func (st *ServiceTunnel) dialDirect(services []*sshproxypb.Service, dial DirectDialer) error {
+	st.directDials.Lock()
+	defer st.directDials.Unlock()
+
+	for _, service := range services {
+		remoteAddr := service.GetRemoteAddress()
+
+		st.mu.Lock()
+		_, exists := st.tunnels[remoteAddr]
+		st.mu.Unlock()
+		if exists {
+			continue
+		}
+
+		user, host, ok := strings.Cut(remoteAddr, "@")
+		if !ok {
+			user, host = "", remoteAddr
+		}
+		tunnel, err := dial(user, host)
+		if err != nil {
+			return errors.Wrapf(err, "dial tunnel of %v", remoteAddr)
+		}
+		lg.Infof("dial ssh tunnel success: %v", remoteAddr)
+
+		st.mu.Lock()
+		st.tunnels[remoteAddr] = tunnel
+		st.mu.Unlock()
+	}
+	return nil
+}
+
+// ConnectEnvs connects the services grouped by env, the tunnel of
+// each env is dialed by dial unless it has been dialed before.
+// It returns the connected nodes and the env of each hostAddr.
+func (st *ServiceTunnel) ConnectEnvs(ctx context.Context, envServices map[string][]*sshproxypb.Service, dial TunnelDialer) ([]*sshproxypb.Node, map[string]string, error) {
+	var services []*sshproxypb.Service
+	for env, srvs := range envServices {
+		host, err := st.envTunnel(env, dial)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, srv := range srvs {
+			srv.RemoteAddress = host
+		}
+		services = append(services, srvs...)
+	}
+
+	resp, err := st.Connect(ctx, &sshproxypb.ConnectRequest{
+		Services: services,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st.mu.Lock()
+	hostEnvs := make(map[string]string, len(st.envHosts))
+	for env, host := range st.envHosts {
+		hostEnvs[host] = env
+	}
+	st.mu.Unlock()
+	return resp.GetConnectedNodes(), hostEnvs, nil
+}
+
+// envTunnel returns the hostAddr of the tunnel of env, which is dialed by dial
+// unless it has been dialed before
+func (st *ServiceTunnel) envTunnel(env string, dial TunnelDialer) (string, error) {
+	st.mu.Lock()
+	dialLock, exists := st.envDials[env]
+	if !exists {
+		dialLock = &sync.Mutex{}
+		st.envDials[env] = dialLock
+	}
+	st.mu.Unlock()
+
+	// the dial may take a while, only the requests of the same env wait for it
+	dialLock.Lock()
+	defer dialLock.Unlock()
+
+	st.mu.Lock()
+	host, exists := st.envHosts[env]
+	st.mu.Unlock()
+	if exists {
+		return host, nil
+	}
+
+	tunnel, err := dial(env)
+	if err != nil {
+		return "", errors.Wrapf(err, "dial tunnel of env %v", env)
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	host = st.envTunnelKey(env, tunnel.GetRemoteHost())
+	st.tunnels[host] = tunnel
+	st.envHosts[env] = host
+	return host, nil
+}
+
+// portScope returns what the local ports of the services through the tunnel of hostAddr
+// are cached by, which is the env of the tunnel, or hostAddr for the tunnels dialed without an env.
+// The tunnel key of an env depends on which env dials a shared host first, the env does not
+func (st *ServiceTunnel) portScope(hostAddr string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for env, host := range st.envHosts {
+		if host == hostAddr {
+			return env
+		}
+	}
+	return hostAddr
+}
+
+// envTunnelKey returns the key of the tunnel of env, which is its remote host unless the host
+// is taken by another tunnel, e.g. of an env reaching the same host through other jumpers.
+// It must be called with st.mu held
+func (st *ServiceTunnel) envTunnelKey(env, host string) string {
+	if _, taken := st.tunnels[host]; taken {
+		return host + "#" + env
+	}
+	return host
+}
+
 func (st *ServiceTunnel) Close() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
 	for _, connectedNodes := range st.connectedMaps {
 		for _, node := range connectedNodes {
 			node.Cancel()
@@ -101,11 +321,13 @@ func (st *ServiceTunnel) Close() {
 		tunnel.Close()
 	}
 
-	st.serviceLocalPortCacheFile.Close()
 	lg.Info("ServiceTunnel closed")
 }
 
 func (st *ServiceTunnel) GetSpecifyRemoteTunnel(host string) (*sshtunnel.SshTunnel, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
 	tunnel, exists := st.tunnels[host]
 	if !exists {
 		return nil, fmt.Errorf("host: %v tunnel not exists", host)
@@ -118,6 +340,9 @@ func (st *ServiceTunnel) Connect(ctx context.Context, in *sshproxypb.ConnectRequ
 
 	connectMaps := st.dialService(ctx, services)
 
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
 	var nodes []*sshproxypb.Node
 	for host, connectMaps := range connectMaps {
 		st.connectedMaps[host] = append(st.connectedMaps[host], connectMaps...)
@@ -125,73 +350,111 @@ func (st *ServiceTunnel) Connect(ctx context.Context, in *sshproxypb.ConnectRequ
 			nodes = append(nodes, cn.Node)
 		}
 	}
+	st.notifyNodesChange()
 
 	return &sshproxypb.ConnectResponse{
 		ConnectedNodes: nodes,
 	}, nil
 }
 
-func (st *ServiceTunnel) buildTunnel(ctx context.Context, remoteAddr, proxyAddr, localAddr string) error {
+// buildTunnel forwards proxyAddr to the local listener l, the tunnel forwards to an internal
+// loopback port and the connections of l are relayed to it to be counted.
+// Forward listens on the port by itself, so the port may be taken after it is picked,
+// which is retried on another port. It returns the loopback address, which is released
+// by wakeForward once ctx is done
+func (st *ServiceTunnel) buildTunnel(ctx context.Context, remoteAddr, proxyAddr string, l net.Listener) (*nodeStats, string, error) {
 	tunnel, err := st.GetSpecifyRemoteTunnel(remoteAddr)
 	if err != nil {
-		return errors.Wrap(err, "GetSpecifyRemoteTunnel")
+		return nil, "", errors.Wrap(err, "GetSpecifyRemoteTunnel")
 	}
 
-	if err := tunnel.Forward(ctx, localAddr, proxyAddr); err != nil {
-		lg.Errorc(ctx, "build tunnel remote: %v -> local: %v error: %v", proxyAddr, localAddr, err)
-		return err
+	var tunnelAddr string
+	for attempt := 1; ; attempt++ {
+		if tunnelAddr, err = loopbackAddr(); err == nil {
+			err = tunnel.Forward(ctx, tunnelAddr, proxyAddr)
+		}
+		if err == nil {
+			break
+		}
+		if attempt == tunnelBindAttempts {
+			lg.Errorc(ctx, "build tunnel remote: %v -> local: %v error: %v", proxyAddr, l.Addr(), err)
+			return nil, "", err
+		}
 	}
 
-	return nil
+	stats := &nodeStats{}
+	go relay(ctx, l, tunnelAddr, stats)
+	return stats, tunnelAddr, nil
 }
 
-func (st *ServiceTunnel) getLocalPortCache(remoteAddr string) string {
-	if cache, ok := st.serviceLocalPortCache[remoteAddr]; ok {
-		return cache.LocalPort
-	}
-
-	return ""
-}
+// getLocalPort returns the cached local port of proxyAddr reached through scope,
+// the port cached by older versions for proxyAddr is taken over by the first scope asking for it,
+// and a random port is picked and cached if there is none
+func (st *ServiceTunnel) getLocalPort(scope, proxyAddr string) (string, error) {
+	st.portsMu.Lock()
+	defer st.portsMu.Unlock()
 
-func (st *ServiceTunnel) writeNewLocalPort(remoteAddr string, localPort string) error {
-	if _, ok := st.serviceLocalPortCache[remoteAddr]; ok {
-		return nil
+	key := portCacheKey(scope, proxyAddr)
+	if cache, ok := st.serviceLocalPortCache[key]; ok {
+		return cache.LocalPort, nil
 	}
 
-	st.serviceLocalPortCache[remoteAddr] = &portCache{
-		RemoteAddr: remoteAddr,
+	var localPort string
+	if cache, ok := st.serviceLocalPortCache[proxyAddr]; ok {
+		localPort = cache.LocalPort
+		delete(st.serviceLocalPortCache, proxyAddr)
+	} else {
+		_, port, err := net.SplitHostPort(randomLocalAddr())
+		if err != nil {
+			return "", errors.Wrap(err, "split local addr")
+		}
+		localPort = port
+	}
+	st.serviceLocalPortCache[key] = &portCache{
+		RemoteAddr: key,
 		LocalPort:  localPort,
 	}
-	st.serviceLocalPortCacheFile.WriteString(fmt.Sprintf("%v-%v\n", remoteAddr, localPort))
-	return nil
+	if err := savePortCache(st.serviceLocalPortCacheFile, key, localPort); err != nil {
+		return "", errors.Wrap(err, "write local port cache")
+	}
+	return localPort, nil
 }
 
 func (st *ServiceTunnel) dialService(ctx context.Context, services []*sshproxypb.Service) map[string][]*connectedNode {
 	mappings := make(map[string][]*connectedNode)
 
 	for _, service := range services {
-		var localAddr string
-		if localPort := st.getLocalPortCache(service.GetProxyAddress()); localPort != "" {
-			localAddr = fmt.Sprintf("[::]:%v", localPort)
+		var localPort string
+		if service.GetLocalPort() != 0 {
+			localPort = strconv.Itoa(int(service.GetLocalPort()))
 		} else {
-			localAddr = randomLocalAddr()
-			_, port, err := net.SplitHostPort(localAddr)
+			port, err := st.getLocalPort(st.portScope(service.GetRemoteAddress()), service.GetProxyAddress())
 			if err != nil {
-				lg.Errorc(ctx, "split local addr error: %v", err)
-				continue
-			}
-			if err := st.writeNewLocalPort(service.GetProxyAddress(), port); err != nil {
-				lg.Errorc(ctx, "write local port cache error: %v", err)
+				lg.Errorc(ctx, "get local port of %v error: %v", service.GetProxyAddress(), err)
 				continue
 			}
+			localPort = port
 		}
+		localHost := service.GetLocalHost()
+		if localHost == "" {
+			localHost = "::"
+		}
+		localAddr := net.JoinHostPort(localHost, localPort)
 		hostAddr := service.GetRemoteAddress()
 		proxyAddr := service.GetProxyAddress()
+		l, err := net.Listen("tcp", localAddr)
+		if err != nil {
+			lg.Errorc(ctx, "listen on local addr %s error: %v", localAddr, err)
+			continue
+		}
 		ctx, cancel := context.WithCancel(context.TODO())
 
 		lg.Infof("build Tunnel: %v-%v-%v", hostAddr, proxyAddr, localAddr)
-		if err := st.buildTunnel(ctx, hostAddr, proxyAddr, localAddr); err != nil {
+		stats, tunnelAddr, err := st.buildTunnel(ctx, hostAddr, proxyAddr, l)
+		if err != nil {
 			lg.Errorf("build tunnel of %v-%v-%v error: %v", hostAddr, proxyAddr, localAddr, err)
+			cancel()
+			l.Close()
 			continue
 		}
 
@@ -205,14 +468,25 @@ func (st *ServiceTunnel) dialService(ctx context.Context, services []*sshproxypb
 				RemoteAddress: proxyAddr,
 				HostAddress:   service.GetRemoteAddress(),
 				ServiceName:   service.GetServiceName(),
+				Tag:           FormatLabels(service.GetLabels()),
+			},
+			Labels: service.GetLabels(),
+			// the local port is released on return, so it can be connected again at once
+			Cancel: func() {
+				cancel()
+				l.Close()
+				wakeForward(tunnelAddr)
 			},
-			Cancel: cancel,
+			stats: stats,
 		})
 	}
 	return mappings
 }
 
 func (st *ServiceTunnel) Disconnect(ctx context.Context, in *sshproxypb.DisconnectRequest) (*sshproxypb.DisconnectResponse, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
 	srvs, exists := st.connectedMaps[in.GetHostAddress()]
 	if !exists {
 		lg.Errorc(ctx, "disconnect host: %v not found", in.GetHostAddress())
@@ -221,7 +495,8 @@ func (st *ServiceTunnel) Disconnect(ctx context.Context, in *sshproxypb.Disconne
 
 	delIdx := -1
 	for idx, srv := range srvs {
-		if srv.Node.GetRemoteAddress() == in.GetProxyAddress() {
+		if srv.Node.GetRemoteAddress() == in.GetProxyAddress() &&
+			(in.GetLocalAddress() == "" || srv.Node.GetLocalAddress() == in.GetLocalAddress()) {
 			srv.Cancel()
 			delIdx = idx
 			break
@@ -231,6 +506,7 @@ func (st *ServiceTunnel) Disconnect(ctx context.Context, in *sshproxypb.Disconne
 	if delIdx != -1 {
 		srvs = append(srvs[:delIdx], srvs[delIdx+1:]...)
 		st.connectedMaps[in.GetHostAddress()] = srvs
+		st.notifyNodesChange()
 	}
 
 	lg.Infoc(ctx, "disconnect service: %v-%v success", in.GetHostAddress(), in.GetProxyAddress())
@@ -239,11 +515,21 @@ func (st *ServiceTunnel) Disconnect(ctx context.Context, in *sshproxypb.Disconne
 }
 
 func (st *ServiceTunnel) GetConnectNodes(ctx context.Context, in *sshproxypb.GetConnectNodesRequest) (*sshproxypb.GetConnectNodesResponse, error) {
+	selector, err := ParseSelector(in.GetSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
 
 	var nodes []*sshproxypb.Node
 	for _, connectedNodes := range st.connectedMaps {
 		for _, n := range connectedNodes {
-			nodes = append(nodes, n.Node)
+			if !selector.Matches(n.Labels) {
+				continue
+			}
+			nodes = append(nodes, n.withStats())
 		}
 	}
 
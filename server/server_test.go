@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+	"github.com/superwhys/sshtunnel"
+)
+
+func TestConnectEnvs_SameHost(t *testing.T) {
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	sshAddr, identityFile := startSSHServer(t)
+	echoAddr := startEchoServer(t)
+
+	st := NewServiceTunnel()
+	defer st.Close()
+	// dev and staging reach the same host, e.g. through different jumpers
+	dial := func(env string) (*sshtunnel.SshTunnel, error) {
+		return sshtunnel.NewTunnel(&sshtunnel.SshConfig{HostName: sshAddr, IdentityFile: identityFile}), nil
+	}
+
+	nodes, hostEnvs, err := st.ConnectEnvs(context.Background(), map[string][]*sshproxypb.Service{
+		"dev":     {{ServiceName: "echo", ProxyAddress: echoAddr, LocalHost: "127.0.0.1"}},
+		"staging": {{ServiceName: "echo", ProxyAddress: echoAddr, LocalHost: "127.0.0.1"}},
+	}, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("ConnectEnvs() connected %d nodes, want 2", len(nodes))
+	}
+	if nodes[0].GetHostAddress() == nodes[1].GetHostAddress() {
+		t.Errorf("the envs share the tunnel %s", nodes[0].GetHostAddress())
+	}
+	if nodes[0].GetLocalAddress() == nodes[1].GetLocalAddress() {
+		t.Errorf("the envs share the local address %s", nodes[0].GetLocalAddress())
+	}
+	for _, node := range nodes {
+		if hostEnvs[node.GetHostAddress()] == "" {
+			t.Errorf("no env of host %s in %v", node.GetHostAddress(), hostEnvs)
+		}
+		echoThrough(t, NodeLocalAddr(node))
+	}
+}
+
+func TestConnectEnvs_SameHostPorts(t *testing.T) {
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	sshAddr, identityFile := startSSHServer(t)
+	echoAddr := startEchoServer(t)
+	dial := func(env string) (*sshtunnel.SshTunnel, error) {
+		return sshtunnel.NewTunnel(&sshtunnel.SshConfig{HostName: sshAddr, IdentityFile: identityFile}), nil
+	}
+
+	// the envs are dialed in the random order of the map on each run
+	envPorts := make(map[string]string)
+	for run := 0; run < 5; run++ {
+		st := NewServiceTunnel()
+		nodes, hostEnvs, err := st.ConnectEnvs(context.Background(), map[string][]*sshproxypb.Service{
+			"dev":     {{ServiceName: "echo", ProxyAddress: echoAddr, LocalHost: "127.0.0.1"}},
+			"staging": {{ServiceName: "echo", ProxyAddress: echoAddr, LocalHost: "127.0.0.1"}},
+		}, dial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes {
+			env := hostEnvs[node.GetHostAddress()]
+			if port, ok := envPorts[env]; ok && port != node.GetLocalAddress() {
+				t.Errorf("run %d: env %s is connected on %s, want %s", run, env, node.GetLocalAddress(), port)
+			}
+			envPorts[env] = node.GetLocalAddress()
+		}
+		st.Close()
+	}
+}
+
+func TestConnectEnvs_Concurrent(t *testing.T) {
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	sshAddr, identityFile := startSSHServer(t)
+
+	st := NewServiceTunnel()
+	defer st.Close()
+	var dials atomic.Int32
+	dial := func(env string) (*sshtunnel.SshTunnel, error) {
+		dials.Add(1)
+		return sshtunnel.NewTunnel(&sshtunnel.SshConfig{HostName: sshAddr, IdentityFile: identityFile}), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		echoAddr := startEchoServer(t)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := st.ConnectEnvs(context.Background(), map[string][]*sshproxypb.Service{
+				"dev": {{ServiceName: "echo", ProxyAddress: echoAddr, LocalHost: "127.0.0.1"}},
+			}, dial)
+			if err != nil {
+				t.Error(err)
+			}
+			st.GetConnectNodes(context.Background(), &sshproxypb.GetConnectNodesRequest{})
+		}()
+	}
+	wg.Wait()
+
+	if n := dials.Load(); n != 1 {
+		t.Errorf("the tunnel of dev is dialed %d times, want 1", n)
+	}
+	resp, err := st.GetConnectNodes(context.Background(), &sshproxypb.GetConnectNodesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetConnectedNodes()) != 5 {
+		t.Errorf("GetConnectNodes() = %d nodes, want 5", len(resp.GetConnectedNodes()))
+	}
+}
+
+func TestConnectDirect_User(t *testing.T) {
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	sshAddr, identityFile := startSSHServer(t)
+	echoAddr := startEchoServer(t)
+
+	var services []*sshproxypb.Service
+	for _, spec := range []string{"web=admin@" + sshAddr + "/" + echoAddr, "ssh://" + sshAddr + "/" + echoAddr} {
+		parsed, err := ParseServiceSpec(spec, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		services = append(services, parsed.Service())
+	}
+
+	st := NewServiceTunnel()
+	defer st.Close()
+	var users []string
+	dial := func(user, host string) (*sshtunnel.SshTunnel, error) {
+		users = append(users, user)
+		return sshtunnel.NewTunnel(&sshtunnel.SshConfig{HostName: host, User: user, IdentityFile: identityFile}), nil
+	}
+
+	nodes, err := st.ConnectDirect(context.Background(), services, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("ConnectDirect() connected %d nodes, want 2", len(nodes))
+	}
+	if strings.Join(users, ",") != "admin," {
+		t.Errorf("ConnectDirect() dialed with users %q, want [admin ]", users)
+	}
+	for _, node := range nodes {
+		echoThrough(t, NodeLocalAddr(node))
+	}
+}
+
+func TestGetLocalPort_Legacy(t *testing.T) {
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	// migrated from the legacy cache, which is keyed by the address only
+	if err := savePortCache(localPortCacheFile, "redis:6379", "35001"); err != nil {
+		t.Fatal(err)
+	}
+
+	st := NewServiceTunnel()
+	if port, err := st.getLocalPort("dev", "redis:6379"); err != nil || port != "35001" {
+		t.Errorf("getLocalPort(dev) = %v, %v, want the legacy port 35001", port, err)
+	}
+	if port, err := st.getLocalPort("staging", "redis:6379"); err != nil || port == "35001" {
+		t.Errorf("getLocalPort(staging) = %v, %v, want another port than dev", port, err)
+	}
+
+	ports, err := LocalPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := ports[portCacheKey("dev", "redis:6379")]; port != "35001" {
+		t.Errorf("cached port of dev = %q, want 35001", port)
+	}
+}
+
+func TestDisconnect_LocalAddress(t *testing.T) {
+	localPortCacheFile = filepath.Join(t.TempDir(), portCacheFile)
+	sshAddr, identityFile := startSSHServer(t)
+	echoAddr := startEchoServer(t)
+
+	st := NewServiceTunnel()
+	defer st.Close()
+	dial := func(env string) (*sshtunnel.SshTunnel, error) {
+		return sshtunnel.NewTunnel(&sshtunnel.SshConfig{HostName: sshAddr, IdentityFile: identityFile}), nil
+	}
+	// e.g. two meshes of dev with the same service, on their own local ports
+	var nodes []*sshproxypb.Node
+	for i := 0; i < 2; i++ {
+		connected, _, err := st.ConnectEnvs(context.Background(), map[string][]*sshproxypb.Service{
+			"dev": {{ServiceName: "echo", ProxyAddress: echoAddr, LocalHost: "127.0.0.1", LocalPort: int32(freePort(t))}},
+		}, dial)
+		if err != nil || len(connected) != 1 {
+			t.Fatalf("ConnectEnvs() = %v, %v", connected, err)
+		}
+		nodes = append(nodes, connected[0])
+	}
+
+	if _, err := st.Disconnect(context.Background(), &sshproxypb.DisconnectRequest{
+		HostAddress:  nodes[1].GetHostAddress(),
+		ProxyAddress: echoAddr,
+		LocalAddress: nodes[1].GetLocalAddress(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := st.GetConnectNodes(context.Background(), &sshproxypb.GetConnectNodesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left := resp.GetConnectedNodes(); len(left) != 1 || left[0].GetLocalAddress() != nodes[0].GetLocalAddress() {
+		t.Errorf("nodes after disconnecting %s = %v, want the one on %s", nodes[1].GetLocalAddress(), left, nodes[0].GetLocalAddress())
+	}
+}
+
+// freePort returns a port which is free on the loopback address
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/superwhys/ssh-proxy/sshproxypb"
+)
+
+const (
+	directSpecGrammar  = "[name=][user@]sshhost[:port]/target:port[@[bind:]localport]"
+	profileSpecGrammar = "[name=]target:port[@[bind:]localport]"
+	sshURIScheme       = "ssh://"
+)
+
+var serviceNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ServiceSpec is a service given on the command line, in the form of
+// [name=][user@]sshhost[:port]/target:port[@[bind:]localport] or ssh:// URI in direct mode,
+// and [name=]target:port[@[bind:]localport] with a profile
+type ServiceSpec struct {
+	Name string
+	// User and SSHHost are only set in direct mode
+	User    string
+	SSHHost string
+	Target  string
+
+	LocalHost string
+	LocalPort int
+}
+
+func parsePort(port string) (int, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", port)
+	}
+	return p, nil
+}
+
+// parseHostPort parses host:port with a non-empty host and a valid port
+func parseHostPort(addr string) (string, int, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid address %q, it should be host:port", addr)
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("empty host in %q", addr)
+	}
+	p, err := parsePort(port)
+	if err != nil {
+		return "", 0, err
+	}
+	return host, p, nil
+}
+
+// validateServiceAddr checks the host:port of a service, the templated addresses of meshes,
+// e.g. api.{{.shard}}.internal:{{.port}}, are only known after Render
+func validateServiceAddr(addr string) error {
+	if strings.Contains(addr, "{{") {
+		return nil
+	}
+	_, _, err := parseHostPort(addr)
+	return err
+}
+
+// ParseServiceSpec parses the service of a command line arg,
+// direct is whether the ssh host is given in the spec instead of a profile
+func ParseServiceSpec(spec string, direct bool) (*ServiceSpec, error) {
+	grammar := profileSpecGrammar
+	if direct {
+		grammar = directSpecGrammar
+	}
+	invalid := func(format string, v ...any) error {
+		return fmt.Errorf("invalid service %q: %s, it should be %s", spec, fmt.Sprintf(format, v...), grammar)
+	}
+
+	s := &ServiceSpec{}
+	rest := spec
+	if direct && strings.HasPrefix(rest, sshURIScheme) {
+		rest = strings.TrimPrefix(rest, sshURIScheme)
+	} else if name, after, ok := strings.Cut(rest, "="); ok {
+		if !serviceNameRegexp.MatchString(name) {
+			return nil, invalid("invalid name %q", name)
+		}
+		s.Name = name
+		rest = after
+		if direct && strings.HasPrefix(rest, sshURIScheme) {
+			rest = strings.TrimPrefix(rest, sshURIScheme)
+		}
+	}
+
+	if direct {
+		sshHost, target, ok := strings.Cut(rest, "/")
+		if !ok {
+			return nil, invalid("missing /target:port")
+		}
+		if user, host, ok := strings.Cut(sshHost, "@"); ok {
+			if user == "" {
+				return nil, invalid("empty user")
+			}
+			s.User = user
+			sshHost = host
+		}
+		if sshHost == "" {
+			return nil, invalid("empty ssh host")
+		}
+		// the ssh port is optional, defaults.Port of the config or 22 is used when dialing
+		if _, _, err := net.SplitHostPort(sshHost); err == nil {
+			if _, _, err := parseHostPort(sshHost); err != nil {
+				return nil, invalid("ssh host: %v", err)
+			}
+		} else if host := strings.Trim(sshHost, "[]"); strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return nil, invalid("ssh host: invalid address %q", sshHost)
+		}
+		s.SSHHost = sshHost
+		rest = target
+	}
+
+	target, local, hasLocal := strings.Cut(rest, "@")
+	if target == "" {
+		return nil, invalid("empty target")
+	}
+	// the targets of direct services are connected as given, which can not be templated
+	var err error
+	if direct {
+		_, _, err = parseHostPort(target)
+	} else {
+		err = validateServiceAddr(target)
+	}
+	if err != nil {
+		return nil, invalid("target: %v", err)
+	}
+	s.Target = target
+
+	if hasLocal {
+		port := local
+		if strings.Contains(local, ":") {
+			host, p, err := net.SplitHostPort(local)
+			if err != nil {
+				return nil, invalid("invalid local address %q, it should be [bind:]localport", local)
+			}
+			s.LocalHost, port = host, p
+		}
+		p, err := parsePort(port)
+		if err != nil {
+			return nil, invalid("invalid local port %q", port)
+		}
+		s.LocalPort = p
+	}
+
+	return s, nil
+}
+
+// RemoteAddress returns the ssh host of the spec with the user if given
+func (s *ServiceSpec) RemoteAddress() string {
+	if s.User == "" {
+		return s.SSHHost
+	}
+	return s.User + "@" + s.SSHHost
+}
+
+// Service returns the service to connect, which is named by the target if no name given
+func (s *ServiceSpec) Service() *sshproxypb.Service {
+	name := s.Name
+	if name == "" {
+		name = s.Target
+	}
+	return &sshproxypb.Service{
+		ServiceName:   name,
+		RemoteAddress: s.RemoteAddress(),
+		ProxyAddress:  s.Target,
+		LocalPort:     int32(s.LocalPort),
+		LocalHost:     s.LocalHost,
+	}
+}
+
+// ParseServiceList parses the services of a list file, one service spec per line,
+// blank lines and the comments after # are ignored
+func ParseServiceList(b []byte) []string {
+	var specs []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		// a legacy sshHost:sshPort proxyHost:proxyPort pair is kept as two args
+		specs = append(specs, strings.Fields(line)...)
+	}
+	return specs
+}
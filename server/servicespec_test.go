@@ -0,0 +1,87 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseServiceSpec(t *testing.T) {
+	tests := []struct {
+		spec   string
+		direct bool
+		want   *ServiceSpec
+	}{
+		{"localhost:8000", false, &ServiceSpec{Target: "localhost:8000"}},
+		{"redis=redis.internal:6379@16379", false, &ServiceSpec{Name: "redis", Target: "redis.internal:6379", LocalPort: 16379}},
+		{"mysql:3306@127.0.0.1:13306", false, &ServiceSpec{Target: "mysql:3306", LocalHost: "127.0.0.1", LocalPort: 13306}},
+		{"[::1]:80@[::1]:8080", false, &ServiceSpec{Target: "[::1]:80", LocalHost: "::1", LocalPort: 8080}},
+		{"api.{{.shard}}.internal:{{.port}}@18080", false, &ServiceSpec{Target: "api.{{.shard}}.internal:{{.port}}", LocalPort: 18080}},
+		{"bastion/redis:6379", true, &ServiceSpec{SSHHost: "bastion", Target: "redis:6379"}},
+		{"[::1]/redis:6379", true, &ServiceSpec{SSHHost: "[::1]", Target: "redis:6379"}},
+		{"db=admin@bastion:2222/mysql:3306@13306", true, &ServiceSpec{Name: "db", User: "admin", SSHHost: "bastion:2222", Target: "mysql:3306", LocalPort: 13306}},
+		{"ssh://admin@bastion/redis:6379", true, &ServiceSpec{User: "admin", SSHHost: "bastion", Target: "redis:6379"}},
+		{"cache=ssh://bastion:2222/redis:6379", true, &ServiceSpec{Name: "cache", SSHHost: "bastion:2222", Target: "redis:6379"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseServiceSpec(tt.spec, tt.direct)
+		if err != nil {
+			t.Errorf("ParseServiceSpec(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseServiceSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseServiceSpec_Invalid(t *testing.T) {
+	tests := []struct {
+		spec   string
+		direct bool
+		reason string
+	}{
+		{"redis", false, "target"},
+		{"redis:http", false, `invalid port "http"`},
+		{"redis:6379@0", false, "local"},
+		{"bad name=redis:6379", false, "invalid name"},
+		{"bastion:22", true, "missing /target:port"},
+		{"@bastion/redis:6379", true, "empty user"},
+		{"bastion:ssh/redis:6379", true, "ssh host"},
+		{"bastion:22:22/redis:6379", true, "ssh host"},
+	}
+	for _, tt := range tests {
+		_, err := ParseServiceSpec(tt.spec, tt.direct)
+		if err == nil {
+			t.Errorf("ParseServiceSpec(%q) should fail", tt.spec)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.reason) || !strings.Contains(err.Error(), tt.spec) {
+			t.Errorf("ParseServiceSpec(%q) error = %v, want it to contain %q", tt.spec, err, tt.reason)
+		}
+	}
+}
+
+func TestServiceSpec_Service(t *testing.T) {
+	spec, err := ParseServiceSpec("admin@bastion/redis:6379@16379", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := spec.Service()
+	if srv.GetServiceName() != "redis:6379" || srv.GetRemoteAddress() != "admin@bastion" || srv.GetLocalPort() != 16379 {
+		t.Errorf("ServiceSpec.Service() = %v", srv)
+	}
+}
+
+func TestParseServiceList(t *testing.T) {
+	list := `# services of dev
+redis
+db=mysql.internal:3306@13306   # the primary
+
+bastion:22 api:8080
+`
+	want := []string{"redis", "db=mysql.internal:3306@13306", "bastion:22", "api:8080"}
+	if got := ParseServiceList([]byte(list)); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseServiceList() = %v, want %v", got, want)
+	}
+}
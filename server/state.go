@@ -0,0 +1,279 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+)
+
+const (
+	// StateVersion is the schema version of the state dir written by this version,
+	// bump it and append a migration whenever the format of any state file changes
+	StateVersion = 1
+
+	stateFile     = "state.json"
+	meshFile      = "meshes.json"
+	meshBoltDB    = "meshes.db"
+	portCacheFile = "ports.json"
+)
+
+// stateDirPerm keeps the state dir private, it contains internal hostnames
+var stateDirPerm os.FileMode = 0700
+
+// StateDir returns the dir of all the ssh-proxy state,
+// $SSH_PROXY_STATE_DIR, $XDG_STATE_HOME/ssh-proxy or ~/.local/state/ssh-proxy
+func StateDir() string {
+	if dir := os.Getenv("SSH_PROXY_STATE_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "ssh-proxy")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local", "state", "ssh-proxy")
+}
+
+// statePath returns the path of a state file in the default state dir
+func statePath(name string) string {
+	return filepath.Join(StateDir(), name)
+}
+
+// OpenStateLog opens the log file of the name in the state dir for appending,
+// which is kept private as the other state files
+func OpenStateLog(name string) (*os.File, error) {
+	if err := os.MkdirAll(StateDir(), stateDirPerm); err != nil {
+		return nil, errors.Wrap(err, "create state dir")
+	}
+	f, err := os.OpenFile(statePath(name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, meshFilePerm)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", name)
+	}
+	return f, nil
+}
+
+// State is the versioned state dir, the legacy paths are the files
+// written by the versions before the state dir and are migrated into it
+type State struct {
+	Dir string
+
+	LegacyMeshFile  string
+	LegacyMeshDB    string
+	LegacyPortCache string
+}
+
+func NewState(dir string) *State {
+	return &State{
+		Dir:             dir,
+		LegacyMeshFile:  filepath.Join(os.Getenv("HOME"), ".ssh-proxy-mesh.json"),
+		LegacyMeshDB:    filepath.Join(os.Getenv("HOME"), ".ssh-proxy-mesh.db"),
+		LegacyPortCache: "/tmp/.service-tunnel-local-port-cache",
+	}
+}
+
+func (s *State) path(name string) string {
+	return filepath.Join(s.Dir, name)
+}
+
+type stateMeta struct {
+	Version int `json:"version"`
+	// CurrentEnv is the env used by the commands without --env
+	CurrentEnv string `json:"currentEnv,omitempty"`
+}
+
+func (s *State) loadMeta() (*stateMeta, error) {
+	meta := &stateMeta{}
+	b, err := os.ReadFile(s.path(stateFile))
+	if os.IsNotExist(err) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read state")
+	}
+
+	if err := json.Unmarshal(b, meta); err != nil {
+		return nil, errors.Wrap(err, "parse state")
+	}
+	return meta, nil
+}
+
+// updateMeta runs fn on the state meta and writes it back
+func (s *State) updateMeta(fn func(meta *stateMeta)) error {
+	meta, err := s.loadMeta()
+	if err != nil {
+		return err
+	}
+	fn(meta)
+
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(s.path(stateFile), b, meshFilePerm)
+}
+
+// Version returns the schema version of the state dir, 0 if it is not created yet
+func (s *State) Version() (int, error) {
+	meta, err := s.loadMeta()
+	if err != nil {
+		return 0, err
+	}
+	return meta.Version, nil
+}
+
+func (s *State) setVersion(version int) error {
+	return s.updateMeta(func(meta *stateMeta) {
+		meta.Version = version
+	})
+}
+
+// CurrentEnv returns the env selected by SetCurrentEnv, empty if none
+func (s *State) CurrentEnv() (string, error) {
+	meta, err := s.loadMeta()
+	if err != nil {
+		return "", err
+	}
+	return meta.CurrentEnv, nil
+}
+
+// SetCurrentEnv persists the current env, an empty env clears it
+func (s *State) SetCurrentEnv(env string) error {
+	if err := os.MkdirAll(s.Dir, stateDirPerm); err != nil {
+		return errors.Wrap(err, "create state dir")
+	}
+	lock := flock.New(s.path("state.lock"))
+	if err := lock.Lock(); err != nil {
+		return errors.Wrap(err, "lock state dir")
+	}
+	defer lock.Unlock()
+
+	return s.updateMeta(func(meta *stateMeta) {
+		meta.CurrentEnv = env
+	})
+}
+
+type migration struct {
+	version     int
+	description string
+	// migrate returns the steps it takes, nothing is written if dryRun
+	migrate func(s *State, dryRun bool) ([]string, error)
+}
+
+var migrations = []migration{
+	{
+		version:     1,
+		description: "move the legacy mesh store and port cache into the state dir",
+		migrate:     migrateLegacyFiles,
+	},
+}
+
+// Migrate upgrades the state dir to StateVersion and returns the steps taken,
+// with dryRun the steps are only planned
+func (s *State) Migrate(dryRun bool) ([]string, error) {
+	if !dryRun {
+		if err := os.MkdirAll(s.Dir, stateDirPerm); err != nil {
+			return nil, errors.Wrap(err, "create state dir")
+		}
+		lock := flock.New(s.path("state.lock"))
+		if err := lock.Lock(); err != nil {
+			return nil, errors.Wrap(err, "lock state dir")
+		}
+		defer lock.Unlock()
+	}
+
+	current, err := s.Version()
+	if err != nil {
+		return nil, err
+	}
+	if current > StateVersion {
+		return nil, fmt.Errorf("state version %d in %s is newer than %d supported, please upgrade ssh-proxy", current, s.Dir, StateVersion)
+	}
+
+	var steps []string
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		steps = append(steps, fmt.Sprintf("migrate state to version %d: %s", m.version, m.description))
+		mSteps, err := m.migrate(s, dryRun)
+		if err != nil {
+			return steps, errors.Wrapf(err, "migrate state to version %d", m.version)
+		}
+		steps = append(steps, mSteps...)
+
+		if dryRun {
+			continue
+		}
+		if err := s.setVersion(m.version); err != nil {
+			return steps, errors.Wrap(err, "write state version")
+		}
+	}
+	return steps, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// migrateLegacyFiles copies the legacy files into the state dir in the current format,
+// the legacy files are kept in case of downgrade
+func migrateLegacyFiles(s *State, dryRun bool) ([]string, error) {
+	var steps []string
+
+	if dst := s.path(meshFile); fileExists(s.LegacyMeshFile) && !fileExists(dst) {
+		steps = append(steps, fmt.Sprintf("copy mesh file %s to %s", s.LegacyMeshFile, dst))
+		if !dryRun {
+			b, err := os.ReadFile(s.LegacyMeshFile)
+			if err != nil {
+				return steps, errors.Wrap(err, "read legacy mesh file")
+			}
+			meshes, err := parseMeshFile(b)
+			if err != nil {
+				return steps, err
+			}
+			b, err = marshalMeshFile(meshes)
+			if err != nil {
+				return steps, err
+			}
+			if err := WriteFileAtomic(dst, b, meshFilePerm); err != nil {
+				return steps, err
+			}
+		}
+	}
+
+	if dst := s.path(meshBoltDB); fileExists(s.LegacyMeshDB) && !fileExists(dst) {
+		steps = append(steps, fmt.Sprintf("copy mesh db %s to %s", s.LegacyMeshDB, dst))
+		if !dryRun {
+			b, err := os.ReadFile(s.LegacyMeshDB)
+			if err != nil {
+				return steps, errors.Wrap(err, "read legacy mesh db")
+			}
+			if err := WriteFileAtomic(dst, b, meshFilePerm); err != nil {
+				return steps, err
+			}
+		}
+	}
+
+	if dst := s.path(portCacheFile); fileExists(s.LegacyPortCache) && !fileExists(dst) {
+		steps = append(steps, fmt.Sprintf("copy port cache %s to %s", s.LegacyPortCache, dst))
+		if !dryRun {
+			b, err := os.ReadFile(s.LegacyPortCache)
+			if err != nil {
+				return steps, errors.Wrap(err, "read legacy port cache")
+			}
+			b, err = marshalPortCache(parseLegacyPortCache(b))
+			if err != nil {
+				return steps, err
+			}
+			if err := WriteFileAtomic(dst, b, meshFilePerm); err != nil {
+				return steps, err
+			}
+		}
+	}
+
+	return steps, nil
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testState(t *testing.T) *State {
+	legacy := t.TempDir()
+	s := NewState(filepath.Join(t.TempDir(), "ssh-proxy"))
+	s.LegacyMeshFile = filepath.Join(legacy, ".ssh-proxy-mesh.json")
+	s.LegacyMeshDB = filepath.Join(legacy, ".ssh-proxy-mesh.db")
+	s.LegacyPortCache = filepath.Join(legacy, ".service-tunnel-local-port-cache")
+	return s
+}
+
+func TestState_Migrate(t *testing.T) {
+	s := testState(t)
+	if err := os.WriteFile(s.LegacyMeshFile, []byte(`[{"Name":"mesh-1","Env":"dev","Services":[{"ServiceName":"redis","RemoteAddr":"redis:6379"}]}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.LegacyPortCache, []byte("redis:6379-35001\nmysql:3306-35002\napi.dev-42.internal:8080-35003\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := s.Migrate(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 {
+		t.Errorf("State.Migrate() dry run got steps %v, want 3 steps", steps)
+	}
+	if _, err := os.Stat(s.Dir); !os.IsNotExist(err) {
+		t.Errorf("State.Migrate() dry run should not create the state dir, got %v", err)
+	}
+
+	if _, err := s.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := s.Version(); err != nil || version != StateVersion {
+		t.Errorf("State.Version() = %v, %v, want %v", version, err, StateVersion)
+	}
+
+	meshes, err := NewFileMeshStore(s.path(meshFile)).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mesh{{Name: "mesh-1", Env: "dev", Services: []Service{{ServiceName: "redis", RemoteAddr: "redis:6379"}}}}
+	if !reflect.DeepEqual(meshes, want) {
+		t.Errorf("migrated meshes = %v, want %v", meshes, want)
+	}
+
+	ports, err := loadPortCache(s.path(portCacheFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"redis:6379": "35001", "mysql:3306": "35002", "api.dev-42.internal:8080": "35003"}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("migrated ports = %v, want %v", ports, want)
+	}
+
+	steps, err = s.Migrate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 0 {
+		t.Errorf("State.Migrate() again got steps %v, want none", steps)
+	}
+}
+
+func TestState_MigrateNewerVersion(t *testing.T) {
+	s := testState(t)
+	if err := os.MkdirAll(s.Dir, stateDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.setVersion(StateVersion + 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Migrate(false); err == nil {
+		t.Error("State.Migrate() should fail on a newer state version")
+	}
+}
+
+func TestSavePortCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", portCacheFile)
+	if err := savePortCache(path, "redis:6379", "35001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := savePortCache(path, "mysql:3306", "35002"); err != nil {
+		t.Fatal(err)
+	}
+
+	ports, err := loadPortCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"redis:6379": "35001", "mysql:3306": "35002"}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("loadPortCache() = %v, want %v", ports, want)
+	}
+}
+
+func TestState_CurrentEnv(t *testing.T) {
+	s := testState(t)
+	if env, err := s.CurrentEnv(); err != nil || env != "" {
+		t.Errorf("State.CurrentEnv() = %v, %v, want empty", env, err)
+	}
+
+	if err := s.SetCurrentEnv("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Migrate(false); err != nil {
+		t.Fatal(err)
+	}
+	if env, err := s.CurrentEnv(); err != nil || env != "dev" {
+		t.Errorf("State.CurrentEnv() = %v, %v, want dev", env, err)
+	}
+	if version, err := s.Version(); err != nil || version != StateVersion {
+		t.Errorf("State.Version() = %v, %v, want %v", version, err, StateVersion)
+	}
+
+	if err := s.SetCurrentEnv(""); err != nil {
+		t.Fatal(err)
+	}
+	if env, err := s.CurrentEnv(); err != nil || env != "" {
+		t.Errorf("State.CurrentEnv() = %v, %v, want empty after clear", env, err)
+	}
+}
+
+func TestSplitPortCacheKey(t *testing.T) {
+	tests := []struct {
+		key, scope, addr string
+	}{
+		{portCacheKey("bastion:22", "redis:6379"), "bastion:22", "redis:6379"},
+		{portCacheKey("dev", "redis_cache.internal:6379"), "dev", "redis_cache.internal:6379"},
+		{portCacheKey("ops@bastion_1:22", "[::1]:6379"), "ops@bastion_1:22", "[::1]:6379"},
+		{"redis:6379", "", "redis:6379"},
+	}
+	for _, tt := range tests {
+		if scope, addr := SplitPortCacheKey(tt.key); scope != tt.scope || addr != tt.addr {
+			t.Errorf("SplitPortCacheKey(%q) = %q, %q, want %q, %q", tt.key, scope, addr, tt.scope, tt.addr)
+		}
+	}
+}
+
+func TestOpenStateLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ssh-proxy")
+	t.Setenv("SSH_PROXY_STATE_DIR", dir)
+
+	f, err := OpenStateLog("ui.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for p, want := range map[string]os.FileMode{dir: stateDirPerm, f.Name(): meshFilePerm} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != want {
+			t.Errorf("%s perm = %v, want %v", p, perm, want)
+		}
+	}
+}
@@ -181,3 +181,317 @@ var ServiceTunnel_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "sshproxypb/sshproxy.proto",
 }
+
+const (
+	MeshService_CreateMesh_FullMethodName    = "/MeshService/CreateMesh"
+	MeshService_GetMesh_FullMethodName       = "/MeshService/GetMesh"
+	MeshService_ListMeshes_FullMethodName    = "/MeshService/ListMeshes"
+	MeshService_AppendService_FullMethodName = "/MeshService/AppendService"
+	MeshService_RemoveService_FullMethodName = "/MeshService/RemoveService"
+	MeshService_DeleteMesh_FullMethodName    = "/MeshService/DeleteMesh"
+	MeshService_ConnectMesh_FullMethodName   = "/MeshService/ConnectMesh"
+)
+
+// MeshServiceClient is the client API for MeshService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MeshServiceClient interface {
+	CreateMesh(ctx context.Context, in *CreateMeshRequest, opts ...grpc.CallOption) (*CreateMeshResponse, error)
+	GetMesh(ctx context.Context, in *GetMeshRequest, opts ...grpc.CallOption) (*GetMeshResponse, error)
+	ListMeshes(ctx context.Context, in *ListMeshesRequest, opts ...grpc.CallOption) (*ListMeshesResponse, error)
+	AppendService(ctx context.Context, in *AppendServiceRequest, opts ...grpc.CallOption) (*AppendServiceResponse, error)
+	RemoveService(ctx context.Context, in *RemoveServiceRequest, opts ...grpc.CallOption) (*RemoveServiceResponse, error)
+	DeleteMesh(ctx context.Context, in *DeleteMeshRequest, opts ...grpc.CallOption) (*DeleteMeshResponse, error)
+	// ConnectMesh connects the services of the mesh into the running session
+	ConnectMesh(ctx context.Context, in *ConnectMeshRequest, opts ...grpc.CallOption) (*ConnectMeshResponse, error)
+}
+
+type meshServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMeshServiceClient(cc grpc.ClientConnInterface) MeshServiceClient {
+	return &meshServiceClient{cc}
+}
+
+func (c *meshServiceClient) CreateMesh(ctx context.Context, in *CreateMeshRequest, opts ...grpc.CallOption) (*CreateMeshResponse, error) {
+	out := new(CreateMeshResponse)
+	err := c.cc.Invoke(ctx, MeshService_CreateMesh_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) GetMesh(ctx context.Context, in *GetMeshRequest, opts ...grpc.CallOption) (*GetMeshResponse, error) {
+	out := new(GetMeshResponse)
+	err := c.cc.Invoke(ctx, MeshService_GetMesh_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) ListMeshes(ctx context.Context, in *ListMeshesRequest, opts ...grpc.CallOption) (*ListMeshesResponse, error) {
+	out := new(ListMeshesResponse)
+	err := c.cc.Invoke(ctx, MeshService_ListMeshes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) AppendService(ctx context.Context, in *AppendServiceRequest, opts ...grpc.CallOption) (*AppendServiceResponse, error) {
+	out := new(AppendServiceResponse)
+	err := c.cc.Invoke(ctx, MeshService_AppendService_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) RemoveService(ctx context.Context, in *RemoveServiceRequest, opts ...grpc.CallOption) (*RemoveServiceResponse, error) {
+	out := new(RemoveServiceResponse)
+	err := c.cc.Invoke(ctx, MeshService_RemoveService_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) DeleteMesh(ctx context.Context, in *DeleteMeshRequest, opts ...grpc.CallOption) (*DeleteMeshResponse, error) {
+	out := new(DeleteMeshResponse)
+	err := c.cc.Invoke(ctx, MeshService_DeleteMesh_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) ConnectMesh(ctx context.Context, in *ConnectMeshRequest, opts ...grpc.CallOption) (*ConnectMeshResponse, error) {
+	out := new(ConnectMeshResponse)
+	err := c.cc.Invoke(ctx, MeshService_ConnectMesh_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MeshServiceServer is the server API for MeshService service.
+// All implementations must embed UnimplementedMeshServiceServer
+// for forward compatibility
+type MeshServiceServer interface {
+	CreateMesh(context.Context, *CreateMeshRequest) (*CreateMeshResponse, error)
+	GetMesh(context.Context, *GetMeshRequest) (*GetMeshResponse, error)
+	ListMeshes(context.Context, *ListMeshesRequest) (*ListMeshesResponse, error)
+	AppendService(context.Context, *AppendServiceRequest) (*AppendServiceResponse, error)
+	RemoveService(context.Context, *RemoveServiceRequest) (*RemoveServiceResponse, error)
+	DeleteMesh(context.Context, *DeleteMeshRequest) (*DeleteMeshResponse, error)
+	// ConnectMesh connects the services of the mesh into the running session
+	ConnectMesh(context.Context, *ConnectMeshRequest) (*ConnectMeshResponse, error)
+	mustEmbedUnimplementedMeshServiceServer()
+}
+
+// UnimplementedMeshServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMeshServiceServer struct {
+}
+
+func (UnimplementedMeshServiceServer) CreateMesh(context.Context, *CreateMeshRequest) (*CreateMeshResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateMesh not implemented")
+}
+func (UnimplementedMeshServiceServer) GetMesh(context.Context, *GetMeshRequest) (*GetMeshResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMesh not implemented")
+}
+func (UnimplementedMeshServiceServer) ListMeshes(context.Context, *ListMeshesRequest) (*ListMeshesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMeshes not implemented")
+}
+func (UnimplementedMeshServiceServer) AppendService(context.Context, *AppendServiceRequest) (*AppendServiceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendService not implemented")
+}
+func (UnimplementedMeshServiceServer) RemoveService(context.Context, *RemoveServiceRequest) (*RemoveServiceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveService not implemented")
+}
+func (UnimplementedMeshServiceServer) DeleteMesh(context.Context, *DeleteMeshRequest) (*DeleteMeshResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMesh not implemented")
+}
+func (UnimplementedMeshServiceServer) ConnectMesh(context.Context, *ConnectMeshRequest) (*ConnectMeshResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConnectMesh not implemented")
+}
+func (UnimplementedMeshServiceServer) mustEmbedUnimplementedMeshServiceServer() {}
+
+// UnsafeMeshServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MeshServiceServer will
+// result in compilation errors.
+type UnsafeMeshServiceServer interface {
+	mustEmbedUnimplementedMeshServiceServer()
+}
+
+func RegisterMeshServiceServer(s grpc.ServiceRegistrar, srv MeshServiceServer) {
+	s.RegisterService(&MeshService_ServiceDesc, srv)
+}
+
+func _MeshService_CreateMesh_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateMeshRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).CreateMesh(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MeshService_CreateMesh_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).CreateMesh(ctx, req.(*CreateMeshRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_GetMesh_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMeshRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).GetMesh(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MeshService_GetMesh_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).GetMesh(ctx, req.(*GetMeshRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_ListMeshes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMeshesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).ListMeshes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MeshService_ListMeshes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).ListMeshes(ctx, req.(*ListMeshesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_AppendService_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).AppendService(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MeshService_AppendService_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).AppendService(ctx, req.(*AppendServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_RemoveService_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).RemoveService(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MeshService_RemoveService_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).RemoveService(ctx, req.(*RemoveServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_DeleteMesh_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMeshRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).DeleteMesh(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MeshService_DeleteMesh_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).DeleteMesh(ctx, req.(*DeleteMeshRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_ConnectMesh_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConnectMeshRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).ConnectMesh(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MeshService_ConnectMesh_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).ConnectMesh(ctx, req.(*ConnectMeshRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MeshService_ServiceDesc is the grpc.ServiceDesc for MeshService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MeshService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "MeshService",
+	HandlerType: (*MeshServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateMesh",
+			Handler:    _MeshService_CreateMesh_Handler,
+		},
+		{
+			MethodName: "GetMesh",
+			Handler:    _MeshService_GetMesh_Handler,
+		},
+		{
+			MethodName: "ListMeshes",
+			Handler:    _MeshService_ListMeshes_Handler,
+		},
+		{
+			MethodName: "AppendService",
+			Handler:    _MeshService_AppendService_Handler,
+		},
+		{
+			MethodName: "RemoveService",
+			Handler:    _MeshService_RemoveService_Handler,
+		},
+		{
+			MethodName: "DeleteMesh",
+			Handler:    _MeshService_DeleteMesh_Handler,
+		},
+		{
+			MethodName: "ConnectMesh",
+			Handler:    _MeshService_ConnectMesh_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "sshproxypb/sshproxy.proto",
+}